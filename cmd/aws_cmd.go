@@ -3,12 +3,18 @@ package cmd
 import (
 	"github.com/benchlabs/bub/core"
 	"github.com/benchlabs/bub/integrations/aws"
+	"github.com/benchlabs/bub/utils"
 	"github.com/urfave/cli"
+	"io/ioutil"
 	"log"
+	"os"
+	"strings"
+	"time"
 )
 
 func buildEC2Cmd(cfg *core.Configuration, manifest *core.Manifest) cli.Command {
 	jump := "jump"
+	ssm := "ssm"
 	all := "all"
 	output := "output"
 
@@ -18,10 +24,17 @@ func buildEC2Cmd(cfg *core.Configuration, manifest *core.Manifest) cli.Command {
 			"'jstack' and 'jmap' will be executed inside the container.",
 		ArgsUsage: "[INSTANCE_NAME] [COMMAND ...]",
 		Aliases:   []string{"e"},
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			cli.BoolFlag{Name: jump, Usage: "Use the environment jump host."},
+			cli.BoolFlag{Name: ssm, Usage: "Use SSM Session Manager instead of ssh."},
 			cli.BoolFlag{Name: all, Usage: "Execute the command on all the instance matched."},
 			cli.BoolFlag{Name: output, Usage: "Saves the stdout of the command to a file."},
+		}, instanceFilterFlags()...),
+		Subcommands: []cli.Command{
+			buildEC2ListCmd(cfg),
+			buildEC2RunCmd(cfg, manifest),
+			buildEC2CopyCmd(cfg),
+			buildEC2SSHConfigCmd(cfg),
 		},
 		Action: func(c *cli.Context) error {
 			var (
@@ -31,40 +44,514 @@ func buildEC2Cmd(cfg *core.Configuration, manifest *core.Manifest) cli.Command {
 			if c.NArg() > 0 {
 				name = c.Args().Get(0)
 			} else if manifest.Name != "" {
-				log.Printf("Manifest found. Using '%v'", name)
+				log.Printf("Manifest found. Using '%v'", manifest.Name)
 				name = manifest.Name
 			}
 			if c.NArg() > 1 {
 				args = c.Args()[1:]
 			}
+			filter, err := getInstanceFilter(c, name)
+			if err != nil {
+				return err
+			}
 			return aws.ConnectToInstance(aws.ConnectionParams{
 				Configuration: cfg,
-				Filter:        name,
+				Filter:        filter,
 				Output:        c.Bool(output),
 				All:           c.Bool(all),
 				UseJumpHost:   c.Bool(jump),
+				UseSSM:        c.Bool(ssm),
 				Args:          args},
 			)
 		},
 	}
 }
 
+func buildEC2ListCmd(cfg *core.Configuration) cli.Command {
+	json := "json"
+
+	return cli.Command{
+		Name:      "ls",
+		Usage:     "List the instances matched with their tags, uptime and Beanstalk environment.",
+		ArgsUsage: "[INSTANCE_NAME]",
+		Flags: append([]cli.Flag{
+			cli.BoolFlag{Name: json, Usage: "Print the instances as JSON."},
+		}, instanceFilterFlags()...),
+		Action: func(c *cli.Context) error {
+			filter, err := getInstanceFilter(c, c.Args().First())
+			if err != nil {
+				return err
+			}
+			return aws.ListInstances(cfg, filter, c.Bool(json))
+		},
+	}
+}
+
+func buildEC2RunCmd(cfg *core.Configuration, manifest *core.Manifest) cli.Command {
+	jump := "jump"
+	ssm := "ssm"
+	parallel := "parallel"
+	group := "group"
+	report := "report"
+	yes := "yes"
+
+	return cli.Command{
+		Name:      "run",
+		Usage:     "Run a command on all the instances matched, e.g. 'bub ec2 run api -- jstack'.",
+		ArgsUsage: "[INSTANCE_NAME] -- COMMAND [ARGS ...]",
+		Flags: append([]cli.Flag{
+			cli.BoolFlag{Name: jump, Usage: "Use the environment jump host."},
+			cli.BoolFlag{Name: ssm, Usage: "Use SSM SendCommand instead of ssh."},
+			cli.IntFlag{Name: parallel, Value: 10, Usage: "Maximum number of instances running the command at once."},
+			cli.BoolFlag{Name: group, Usage: "Print the output of each instance once completed, instead of prefixing each line."},
+			cli.StringFlag{Name: report, Usage: "Save a JSON report of the output and exit code of each instance to the file."},
+			cli.BoolFlag{Name: yes, Usage: "Run on all the running instances without confirming, when no name or filter is given."},
+		}, instanceFilterFlags()...),
+		Action: func(c *cli.Context) error {
+			name, args := splitCommandArgs(c.Args(), os.Args)
+			if name == "" && manifest.Name != "" {
+				log.Printf("Manifest found. Using '%v'", manifest.Name)
+				name = manifest.Name
+			}
+			filter, err := getInstanceFilter(c, name)
+			if err != nil {
+				return err
+			}
+			results, err := aws.RunOnInstances(aws.RunParams{
+				Configuration: cfg,
+				Filter:        filter,
+				UseJumpHost:   c.Bool(jump),
+				UseSSM:        c.Bool(ssm),
+				Parallel:      c.Int(parallel),
+				Group:         c.Bool(group),
+				Report:        c.String(report),
+				Confirm:       filter.IsEmpty() && !c.Bool(yes),
+				Args:          args,
+			})
+			if err != nil {
+				return err
+			}
+			aws.PrintRunSummary(results)
+			for _, r := range results {
+				if r.ExitCode != 0 {
+					return cli.NewExitError("", 1)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func buildEC2CopyCmd(cfg *core.Configuration) cli.Command {
+	jump := "jump"
+	ssm := "ssm"
+	parallel := "parallel"
+	recursive := "recursive"
+
+	return cli.Command{
+		Name: "cp",
+		Usage: "Copy files from all the instances matched into a directory per instance, " +
+			"e.g. 'bub ec2 cp api:/tmp/heap.hprof dumps', or to all of them, e.g. 'bub ec2 cp debug.sh api:/tmp/'.",
+		ArgsUsage: "INSTANCE_NAME:REMOTE_PATH LOCAL_PATH | LOCAL_PATH INSTANCE_NAME:REMOTE_PATH",
+		Flags: append([]cli.Flag{
+			cli.BoolFlag{Name: jump, Usage: "Use the environment jump host."},
+			cli.BoolFlag{Name: ssm, Usage: "Tunnel scp through SSM Session Manager."},
+			cli.IntFlag{Name: parallel, Value: 10, Usage: "Maximum number of instances copying at once."},
+			cli.BoolFlag{Name: recursive + ", r", Usage: "Copy directories recursively."},
+		}, instanceFilterFlags()...),
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 2 {
+				return cli.NewExitError("Two arguments are required, the source and the destination.", 1)
+			}
+			name, remote, local, download, err := aws.ParseCopyArgs(c.Args().Get(0), c.Args().Get(1))
+			if err != nil {
+				return err
+			}
+			filter, err := getInstanceFilter(c, name)
+			if err != nil {
+				return err
+			}
+			results, err := aws.CopyFiles(aws.CopyParams{
+				Configuration: cfg,
+				Filter:        filter,
+				UseJumpHost:   c.Bool(jump),
+				UseSSM:        c.Bool(ssm),
+				Parallel:      c.Int(parallel),
+				Recursive:     c.Bool(recursive),
+				Download:      download,
+				Remote:        remote,
+				Local:         local,
+			})
+			if err != nil {
+				return err
+			}
+			aws.PrintRunSummary(results)
+			for _, r := range results {
+				if r.ExitCode != 0 {
+					return cli.NewExitError("", 1)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func buildEC2SSHConfigCmd(cfg *core.Configuration) cli.Command {
+	output := "output"
+
+	return cli.Command{
+		Name: "ssh-config",
+		Usage: "Write an OpenSSH config with a Host entry per running instance, " +
+			"for rsync, ansible or remote development. Run it again to refresh the hosts.",
+		ArgsUsage: "[INSTANCE_NAME]",
+		Flags: append([]cli.Flag{
+			cli.StringFlag{Name: output, Value: aws.GetSSHConfigPath(), Usage: "Path of the generated config."},
+		}, instanceFilterFlags()...),
+		Action: func(c *cli.Context) error {
+			filter, err := getInstanceFilter(c, c.Args().First())
+			if err != nil {
+				return err
+			}
+			return aws.WriteSSHConfig(cfg, filter, c.String(output))
+		},
+	}
+}
+
+func instanceFilterFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringSliceFlag{Name: "tag", Usage: "Filter on a tag, e.g. 'env=staging'. Can be repeated."},
+		cli.StringSliceFlag{Name: "state", Usage: "Filter on the instance state. Defaults to running."},
+		cli.StringSliceFlag{Name: "type", Usage: "Filter on the instance type, e.g. 'm4.large'."},
+		cli.StringSliceFlag{Name: "ami", Usage: "Filter on the AMI id."},
+		cli.StringSliceFlag{Name: "az", Usage: "Filter on the availability zone."},
+		cli.DurationFlag{Name: "older-than", Usage: "Only the instances launched before, e.g. '72h'."},
+		cli.DurationFlag{Name: "newer-than", Usage: "Only the instances launched after, e.g. '30m'."},
+	}
+}
+
+func getInstanceFilter(c *cli.Context, name string) (aws.InstanceFilter, error) {
+	tags, err := aws.ParseTags(c.StringSlice("tag"))
+	if err != nil {
+		return aws.InstanceFilter{}, err
+	}
+	return aws.InstanceFilter{
+		Name:              name,
+		Tags:              tags,
+		States:            c.StringSlice("state"),
+		Types:             c.StringSlice("type"),
+		AMIs:              c.StringSlice("ami"),
+		AvailabilityZones: c.StringSlice("az"),
+		OlderThan:         c.Duration("older-than"),
+		NewerThan:         c.Duration("newer-than"),
+	}, nil
+}
+
+func countTerminators(args []string) int {
+	count := 0
+	for _, arg := range args {
+		if arg == "--" {
+			count++
+		}
+	}
+	return count
+}
+
+// splitCommandArgs splits 'NAME -- COMMAND ...'. Without '--', the first argument is the name.
+// The flag parser drops a leading '--' from args, it is then found in rawArgs, the arguments of the process.
+func splitCommandArgs(args, rawArgs []string) (string, []string) {
+	if countTerminators(rawArgs) > countTerminators(args) {
+		return "", args
+	}
+	for i, arg := range args {
+		if arg == "--" {
+			return strings.Join(args[:i], " "), args[i+1:]
+		}
+	}
+	if len(args) == 0 {
+		return "", nil
+	}
+	return args[0], args[1:]
+}
+
 func buildRDSCmd(cfg *core.Configuration) cli.Command {
+	ssm := "ssm"
+
 	return cli.Command{
 		Name:    "rds",
 		Usage:   "RDS actions.",
 		Aliases: []string{"r"},
+		Flags: []cli.Flag{
+			cli.BoolFlag{Name: ssm, Usage: "Forward the ports with SSM Session Manager instead of the jump host."},
+		},
+		Subcommands: []cli.Command{
+			{
+				Name:      "snapshots",
+				Usage:     "List the automated and manual snapshots of the instances matched.",
+				ArgsUsage: "[INSTANCE_NAME]",
+				Action: func(c *cli.Context) error {
+					return aws.GetRDS(cfg).ListSnapshots(c.Args().First())
+				},
+			},
+			buildRDSCloneCmd(cfg),
+			buildRDSQueryCmd(cfg),
+			buildRDSForwardCmd(cfg),
+			{
+				Name:  "cleanup",
+				Usage: "Delete the expired scratch instances created with 'bub rds clone'.",
+				Flags: []cli.Flag{
+					cli.BoolFlag{Name: "noop", Usage: "Only list the expired instances."},
+				},
+				Action: func(c *cli.Context) error {
+					return aws.GetRDS(cfg).CleanupScratchInstances(c.Bool("noop"))
+				},
+			},
+		},
+		Action: func(c *cli.Context) error {
+			rds := aws.GetRDS(cfg)
+			rds.UseSSM = c.Bool(ssm)
+			return rds.ConnectToRDSInstance(c.Args().First(), c.Args().Tail())
+		},
+	}
+}
+
+func buildRDSQueryCmd(cfg *core.Configuration) cli.Command {
+	ssm := "ssm"
+	file := "file"
+	query := "sql"
+	format := "format"
+	readWrite := "read-write"
+	timeout := "timeout"
+
+	return cli.Command{
+		Name: "query",
+		Usage: "Run a query on all the instances matched and print the merged results, " +
+			"e.g. 'bub rds query api-production --sql \"select count(*) from users\" --format csv'.",
+		ArgsUsage: "[INSTANCE_NAME]",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: file, Usage: "File containing the query."},
+			cli.StringFlag{Name: query, Usage: "The query."},
+			cli.StringFlag{Name: format, Value: "table", Usage: "Output format: table, csv or json."},
+			cli.BoolFlag{Name: readWrite, Usage: "Commit the changes, the query runs in a read-only transaction by default."},
+			cli.DurationFlag{Name: timeout, Value: time.Minute, Usage: "Statement timeout, 0 to disable."},
+			cli.BoolFlag{Name: ssm, Usage: "Forward the ports with SSM Session Manager instead of the jump host."},
+		},
+		Action: func(c *cli.Context) error {
+			sql := c.String(query)
+			if c.String(file) != "" {
+				data, err := ioutil.ReadFile(c.String(file))
+				if err != nil {
+					return err
+				}
+				sql = string(data)
+			}
+			if strings.TrimSpace(sql) == "" {
+				return cli.NewExitError("Provide the query with --sql or --file.", 1)
+			}
+			if c.Bool(readWrite) && !utils.AskForConfirmation("The query will run in a read-write transaction. Continue?") {
+				return nil
+			}
+			rds := aws.GetRDS(cfg)
+			rds.UseSSM = c.Bool(ssm)
+			return rds.QueryRDSInstances(aws.QueryParams{
+				Filter:    c.Args().First(),
+				SQL:       sql,
+				Format:    c.String(format),
+				ReadWrite: c.Bool(readWrite),
+				Timeout:   c.Duration(timeout),
+			})
+		},
+	}
+}
+
+func buildRDSForwardCmd(cfg *core.Configuration) cli.Command {
+	ssm := "ssm"
+	port := "port"
+	pgPass := "pgpass"
+	dsn := "dsn"
+
+	return cli.Command{
+		Name:      "forward",
+		Usage:     "Keep a tunnel to the instance open for GUI clients and local services, until Ctrl-C.",
+		ArgsUsage: "[INSTANCE_NAME]",
+		Flags: []cli.Flag{
+			cli.IntFlag{Name: port, Usage: "Local port, e.g. 5433. A random port is used by default."},
+			cli.BoolFlag{Name: pgPass, Usage: "Add the credentials to ~/.pgpass while the tunnel is open."},
+			cli.BoolFlag{Name: dsn, Usage: "Print the connection string, including the password."},
+			cli.BoolFlag{Name: ssm, Usage: "Forward the ports with SSM Session Manager instead of the jump host."},
+		},
+		Action: func(c *cli.Context) error {
+			rds := aws.GetRDS(cfg)
+			rds.UseSSM = c.Bool(ssm)
+			return rds.ForwardRDSInstance(aws.ForwardParams{
+				Filter: c.Args().First(),
+				Port:   c.Int(port),
+				PgPass: c.Bool(pgPass),
+				DSN:    c.Bool(dsn),
+			})
+		},
+	}
+}
+
+func buildRDSCloneCmd(cfg *core.Configuration) cli.Command {
+	ssm := "ssm"
+	fromSnapshot := "from-snapshot"
+	latest := "latest"
+	name := "name"
+	class := "class"
+	ttl := "ttl"
+
+	return cli.Command{
+		Name: "clone",
+		Usage: "Restore a snapshot of the instance matched into a temporary instance and connect to it, " +
+			"with the credentials of the source instance.",
+		ArgsUsage: "[INSTANCE_NAME] [COMMAND ...]",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: fromSnapshot, Usage: "Identifier of the snapshot to restore."},
+			cli.BoolFlag{Name: latest, Usage: "Restore the most recent snapshot."},
+			cli.StringFlag{Name: name, Value: aws.GetScratchName(), Usage: "Name of the new instance."},
+			cli.StringFlag{Name: class, Usage: "Instance class, defaults to the class of the source instance."},
+			cli.DurationFlag{Name: ttl, Value: 24 * time.Hour, Usage: "Time before 'bub rds cleanup' deletes the instance."},
+			cli.BoolFlag{Name: ssm, Usage: "Forward the ports with SSM Session Manager instead of the jump host."},
+		},
 		Action: func(c *cli.Context) error {
-			return aws.GetRDS(cfg).ConnectToRDSInstance(c.Args().First(), c.Args().Tail())
+			rds := aws.GetRDS(cfg)
+			rds.UseSSM = c.Bool(ssm)
+			return rds.CloneRDSInstance(aws.CloneParams{
+				Filter:   c.Args().First(),
+				Snapshot: c.String(fromSnapshot),
+				Latest:   c.Bool(latest),
+				Name:     c.String(name),
+				Class:    c.String(class),
+				TTL:      c.Duration(ttl),
+				Args:     c.Args().Tail(),
+			})
 		},
 	}
 }
 
 func buildR53Cmd() cli.Command {
+	ttl := "ttl"
+	zone := "zone"
+	format := "format"
+	private := "private"
+	public := "public"
+	zoneFlag := cli.StringFlag{Name: zone, Usage: "Id or name of the hosted zone, inferred from the record name by default."}
+	visibilityFlags := []cli.Flag{
+		cli.BoolFlag{Name: private, Usage: "Use the private zone when a private and a public zone share the name."},
+		cli.BoolFlag{Name: public, Usage: "Use the public zone when a private and a public zone share the name."},
+	}
+	getZoneParams := func(c *cli.Context, zoneName string) aws.ZoneParams {
+		params := aws.ZoneParams{Zone: zoneName, Format: c.String(format)}
+		if c.Bool(private) {
+			params.Visibility = "private"
+		} else if c.Bool(public) {
+			params.Visibility = "public"
+		}
+		return params
+	}
+	// the zone can be omitted, it is read from the file.
+	getZoneFileArgs := func(c *cli.Context) (string, string, error) {
+		switch c.NArg() {
+		case 1:
+			return "", c.Args().First(), nil
+		case 2:
+			return c.Args().Get(0), c.Args().Get(1), nil
+		default:
+			return "", "", cli.NewExitError("The zone file is required.", 1)
+		}
+	}
+
 	return cli.Command{
 		Name:    "route53",
 		Usage:   "R53 actions.",
 		Aliases: []string{"53"},
+		Subcommands: []cli.Command{
+			{
+				Name:      "set",
+				Usage:     "Create or replace a record, e.g. 'bub 53 set api.example.com CNAME lb.example.com'.",
+				ArgsUsage: "NAME TYPE VALUE [VALUE ...]",
+				Flags: []cli.Flag{
+					cli.Int64Flag{Name: ttl, Value: 300, Usage: "TTL in seconds."},
+					zoneFlag,
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() < 3 {
+						return cli.NewExitError("The name, type and value(s) are required.", 1)
+					}
+					return aws.SetRecord(aws.RecordChange{
+						Name:   c.Args().Get(0),
+						Type:   c.Args().Get(1),
+						Values: c.Args()[2:],
+						TTL:    c.Int64(ttl),
+						Zone:   c.String(zone),
+					})
+				},
+			},
+			{
+				Name:      "delete",
+				Usage:     "Delete a record, the values are only checked when given.",
+				ArgsUsage: "NAME TYPE [VALUE ...]",
+				Flags:     []cli.Flag{zoneFlag},
+				Action: func(c *cli.Context) error {
+					if c.NArg() < 2 {
+						return cli.NewExitError("The name and type are required.", 1)
+					}
+					return aws.DeleteRecord(aws.RecordChange{
+						Name:   c.Args().Get(0),
+						Type:   c.Args().Get(1),
+						Values: c.Args()[2:],
+						Zone:   c.String(zone),
+					})
+				},
+			},
+			{
+				Name:      "export",
+				Usage:     "Print the records of a zone, without the SOA and NS records of the apex.",
+				ArgsUsage: "ZONE",
+				Flags: append([]cli.Flag{
+					cli.StringFlag{Name: format, Value: "yaml", Usage: "yaml, to be used with diff and apply, or bind."},
+				}, visibilityFlags...),
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return cli.NewExitError("The zone is required.", 1)
+					}
+					return aws.ExportZone(getZoneParams(c, c.Args().First()))
+				},
+			},
+			{
+				Name:      "diff",
+				Usage:     "Show the changes needed for the zone to match the file, exits with 1 when they differ.",
+				ArgsUsage: "[ZONE] FILE",
+				Flags:     visibilityFlags,
+				Action: func(c *cli.Context) error {
+					zoneName, file, err := getZoneFileArgs(c)
+					if err != nil {
+						return err
+					}
+					drift, err := aws.DiffZone(getZoneParams(c, zoneName), file)
+					if err != nil {
+						return err
+					}
+					if drift {
+						return cli.NewExitError("", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "apply",
+				Usage:     "Update the zone to match the file, in change batches within the Route53 limits.",
+				ArgsUsage: "[ZONE] FILE",
+				Flags:     visibilityFlags,
+				Action: func(c *cli.Context) error {
+					zoneName, file, err := getZoneFileArgs(c)
+					if err != nil {
+						return err
+					}
+					return aws.ApplyZone(getZoneParams(c, zoneName), file)
+				},
+			},
+		},
 		Action: func(c *cli.Context) error {
 			return aws.ListAllRecords(c.Args().First())
 		},
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitCommandArgs(t *testing.T) {
+	t.Parallel()
+	raw := []string{"bub", "ec2", "run", "api", "--", "jstack", "1"}
+	name, args := splitCommandArgs([]string{"api", "--", "jstack", "1"}, raw)
+	assert.Equal(t, "api", name)
+	assert.Equal(t, []string{"jstack", "1"}, args)
+
+	// the flag parser drops the leading '--'.
+	raw = []string{"bub", "ec2", "run", "--", "jstack", "--", "1"}
+	name, args = splitCommandArgs([]string{"jstack", "--", "1"}, raw)
+	assert.Equal(t, "", name)
+	assert.Equal(t, []string{"jstack", "--", "1"}, args)
+
+	raw = []string{"bub", "ec2", "run", "api", "uptime"}
+	name, args = splitCommandArgs([]string{"api", "uptime"}, raw)
+	assert.Equal(t, "api", name)
+	assert.Equal(t, []string{"uptime"}, args)
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/benchlabs/bub/core"
+	"github.com/benchlabs/bub/integrations/ci"
+	"github.com/benchlabs/bub/integrations/github"
+	"github.com/urfave/cli"
+	"time"
+)
+
+// getCIProvider lives here rather than in ci, the GitHub Actions provider depends on the ci package.
+func getCIProvider(cfg *core.Configuration, m *core.Manifest) (ci.Provider, error) {
+	name, err := ci.DetectProvider(m)
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "jenkins":
+		return ci.MustInitJenkins(cfg, m), nil
+	case "circle", "circleci":
+		return ci.NewCircleProvider(cfg, m), nil
+	case "github":
+		return github.NewActionsProvider(cfg, m), nil
+	default:
+		return nil, fmt.Errorf("unknown CI provider '%s', use jenkins, circle or github", name)
+	}
+}
+
+func artifactFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{Name: "path, p", Usage: "Destination directory, the current one by default."},
+		cli.IntFlag{Name: "parallel", Value: 4, Usage: "Maximum number of downloads at once."},
+		cli.BoolFlag{Name: "list, l", Usage: "Only list the matching artifacts."},
+		cli.BoolFlag{Name: "force", Usage: "Download the files already present."},
+	}
+}
+
+// getArtifactParams uses the arguments as glob patterns, e.g. '*.jar' or 'reports/*.xml'.
+func getArtifactParams(c *cli.Context) ci.ArtifactParams {
+	return ci.ArtifactParams{
+		Patterns: c.Args(),
+		Dir:      c.String("path"),
+		Parallel: c.Int("parallel"),
+		List:     c.Bool("list"),
+		Force:    c.Bool("force"),
+	}
+}
+
+func buildCICmd(cfg *core.Configuration, manifest *core.Manifest) cli.Command {
+	commitFlag := cli.StringFlag{Name: "commit", Usage: "Commit of the build, HEAD by default."}
+	getProvider := func(c *cli.Context) (ci.Provider, string, error) {
+		provider, err := getCIProvider(cfg, manifest)
+		if err != nil {
+			return nil, "", err
+		}
+		commit := c.String("commit")
+		if commit == "" {
+			commit, err = core.MustInitGit(".").CurrentHEAD()
+		}
+		return provider, commit, err
+	}
+	checkStatus := func(status *ci.BuildStatus, asJSON bool) error {
+		if err := ci.PrintBuildStatus(status, asJSON); err != nil {
+			return err
+		}
+		if status.State != ci.StateSuccess {
+			return cli.NewExitError("", 1)
+		}
+		return nil
+	}
+	return cli.Command{
+		Name:  "ci",
+		Usage: "CI commands for the commit, on Jenkins, CircleCI or GitHub Actions depending on the repository.",
+		Subcommands: []cli.Command{
+			{
+				Name:    "status",
+				Aliases: []string{"s"},
+				Usage:   "Show the status of the build, exits with 1 unless it succeeded.",
+				Flags: []cli.Flag{
+					commitFlag,
+					cli.BoolFlag{Name: "json", Usage: "Print the status as JSON."},
+				},
+				Action: func(c *cli.Context) error {
+					provider, commit, err := getProvider(c)
+					if err != nil {
+						return err
+					}
+					status, err := provider.Status(commit)
+					if err != nil {
+						return err
+					}
+					return checkStatus(status, c.Bool("json"))
+				},
+			},
+			{
+				Name:    "wait",
+				Aliases: []string{"w"},
+				Usage:   "Wait for the build to complete, exits with 1 unless it succeeded.",
+				Flags: []cli.Flag{
+					commitFlag,
+					cli.DurationFlag{Name: "timeout", Value: time.Hour, Usage: "Give up after the duration, 0 to wait forever."},
+					cli.BoolFlag{Name: "json", Usage: "Print the status as JSON."},
+				},
+				Action: func(c *cli.Context) error {
+					provider, commit, err := getProvider(c)
+					if err != nil {
+						return err
+					}
+					status, err := provider.Wait(commit, c.Duration("timeout"))
+					if err != nil {
+						return err
+					}
+					return checkStatus(status, c.Bool("json"))
+				},
+			},
+			{
+				Name:    "logs",
+				Aliases: []string{"l"},
+				Usage:   "Show the logs of the build, the failed steps on CircleCI and GitHub Actions.",
+				Flags:   []cli.Flag{commitFlag},
+				Action: func(c *cli.Context) error {
+					provider, commit, err := getProvider(c)
+					if err != nil {
+						return err
+					}
+					return provider.Logs(commit)
+				},
+			},
+			{
+				Name:    "tests",
+				Aliases: []string{"t"},
+				Usage:   "Show the failed tests of the build.",
+				Flags:   []cli.Flag{commitFlag},
+				Action: func(c *cli.Context) error {
+					provider, commit, err := getProvider(c)
+					if err != nil {
+						return err
+					}
+					return provider.Tests(commit)
+				},
+			},
+			{
+				Name:      "artifacts",
+				Aliases:   []string{"a"},
+				Usage:     "Download the artifacts of the build.",
+				ArgsUsage: "[PATTERN]...",
+				Flags:     append([]cli.Flag{commitFlag}, artifactFlags()...),
+				Action: func(c *cli.Context) error {
+					provider, commit, err := getProvider(c)
+					if err != nil {
+						return err
+					}
+					return provider.Artifacts(commit, getArtifactParams(c))
+				},
+			},
+		},
+	}
+}
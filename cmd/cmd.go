@@ -7,21 +7,45 @@ import (
 	"os"
 )
 
-func BuildCmds() []cli.Command {
+// configuration is populated by LoadConfiguration once the global flags are parsed.
+var configuration = &core.Configuration{}
+
+func BuildFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:   "profile",
+			EnvVar: "BUB_PROFILE",
+			Usage:  "Use the named configuration profile stored in ~/.config/bub/profiles.",
+		},
+	}
+}
+
+func LoadConfiguration(c *cli.Context) error {
+	core.SetProfile(c.String("profile"))
 	cfg, err := core.LoadConfiguration()
 	if err != nil {
 		log.Printf("The configuration failed to load... %v", err)
+		// doctor reports the broken configuration itself.
+		if c.Args().First() == "doctor" {
+			return nil
+		}
 		core.MustSetupConfig()
 		log.Print("Run 'bub setup' to complete the setup.")
 		os.Exit(0)
 	}
+	*configuration = *cfg
+	return nil
+}
 
+func BuildCmds() []cli.Command {
+	cfg := configuration
 	manifest, _ := core.LoadManifest()
 
 	return []cli.Command{
 		buildSetupCmd(),
 		buildUpdateCmd(cfg),
 		buildConfigCmd(cfg),
+		buildDoctorCmd(cfg),
 		{
 			Name:        "repository",
 			Usage:       "Repository related commands.",
@@ -79,5 +103,6 @@ func BuildCmds() []cli.Command {
 			Usage:       "CircleCI related commands.",
 			Subcommands: buildCircleCmds(cfg, manifest),
 		},
+		buildCICmd(cfg, manifest),
 	}
 }
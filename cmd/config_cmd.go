@@ -4,10 +4,8 @@ import (
 	"errors"
 	"github.com/benchlabs/bub/core"
 	"github.com/benchlabs/bub/integrations/vault"
-	"github.com/benchlabs/bub/utils"
 	"github.com/benchlabs/bub/utils/ssh"
 	"github.com/urfave/cli"
-	"io/ioutil"
 	"log"
 	"strings"
 )
@@ -17,7 +15,12 @@ func buildConfigCmd(cfg *core.Configuration) cli.Command {
 	shared := "shared"
 	loadSharedConfigOpt := "load-shared-config"
 	storeSharedConfigOpt := "store-shared-config"
+	mergeSharedConfigOpt := "merge-shared-config"
+	sharedDiff := "shared-diff"
+	sharedHistory := "shared-history"
+	restoreSharedConfig := "restore-shared-config"
 	preview := "preview"
+	project := "project"
 	return cli.Command{
 		Name:  "config",
 		Usage: "Edit your bub config.",
@@ -25,8 +28,13 @@ func buildConfigCmd(cfg *core.Configuration) cli.Command {
 			cli.BoolFlag{Name: showDefaults, Usage: "Show default config for reference"},
 			cli.BoolFlag{Name: shared, Usage: "Edit shared config."},
 			cli.BoolFlag{Name: loadSharedConfigOpt, Usage: "Load shared config to your config."},
-			cli.BoolFlag{Name: storeSharedConfigOpt, Usage: "Store shared config."},
-			cli.BoolFlag{Name: preview, Usage: "Show/preview the final config."},
+			cli.BoolFlag{Name: storeSharedConfigOpt, Usage: "Store shared config, refused if it changed remotely since the last load."},
+			cli.BoolFlag{Name: mergeSharedConfigOpt, Usage: "Merge the remote shared config changes into your shared config."},
+			cli.BoolFlag{Name: sharedDiff, Usage: "Show the diff between the remote and your shared config."},
+			cli.BoolFlag{Name: sharedHistory, Usage: "List the versions of the shared config."},
+			cli.IntFlag{Name: restoreSharedConfig, Usage: "Restore the `VERSION` of the shared config locally, store it to publish it."},
+			cli.BoolFlag{Name: preview, Usage: "Show/preview the final config and which layer set each key."},
+			cli.BoolFlag{Name: project, Usage: "Edit the repository config overlay (" + core.ConfigProjectFile + ")."},
 		},
 		Action: func(c *cli.Context) error {
 			if c.Bool(showDefaults) {
@@ -42,8 +50,23 @@ func buildConfigCmd(cfg *core.Configuration) cli.Command {
 			if c.Bool(storeSharedConfigOpt) {
 				return storeSharedConfig(cfg)
 			}
+			if c.Bool(mergeSharedConfigOpt) {
+				return mergeSharedConfig(cfg)
+			}
+			if c.Bool(sharedDiff) {
+				return diffSharedConfig(cfg)
+			}
+			if c.Bool(sharedHistory) {
+				return listSharedConfigHistory(cfg)
+			}
+			if c.IsSet(restoreSharedConfig) {
+				return restoreSharedConfigVersion(cfg, c.Int(restoreSharedConfig))
+			}
 			if c.Bool(preview) {
-				return core.ShowConfig(cfg)
+				return core.ShowConfig()
+			}
+			if c.Bool(project) {
+				return core.EditProjectConfiguration()
 			}
 			log.Printf("Use 'bub config --shared' to edit the shared config.")
 			return core.EditConfiguration(core.ConfigUserFile)
@@ -71,67 +94,11 @@ func prepareTunnel(cfg *core.Configuration, env string) (*ssh.Connection, error)
 			"vault": vault.GetVaultTunnelConfiguration(environment),
 		},
 	}
-	tunnel.Connect()
-	return &tunnel, nil
-}
-
-func storeSharedConfig(cfg *core.Configuration) error {
-	if !utils.AskForConfirmation("Store the shared config to Vault?") {
-		log.Print("Aborting...")
-		return nil
-	}
-	tunnel, err := prepareTunnel(cfg, "dev")
-	if err != nil {
-		return err
-	}
-	data, err := ioutil.ReadFile(core.GetConfigPath(core.ConfigSharedFile))
-	if err != nil {
-		return err
-	}
-	payload := make(map[string]interface{})
-	payload["shared"] = string(data)
-
-	_, err = vault.MustInitVault(cfg, tunnel).Write(cfg.Vault.Path, payload)
-	if err != nil {
-		return err
-	}
-	log.Println("The shared config has been updated.")
-	return nil
-}
-
-func loadSharedConfig(cfg *core.Configuration) error {
-	if cfg.Vault.Path == "" {
-		return errors.New("the path configuration for vault is missing")
-	}
-	tunnel, err := prepareTunnel(cfg, "dev")
-	if err != nil {
-		return err
-	}
-	secret, err := vault.MustInitVault(cfg, tunnel).Read(cfg.Vault.Path)
-	if err != nil {
-		return err
-	}
-	if secret == nil {
-		return errors.New("no secret found")
+	if environment.UsesSSM() {
+		tunnel.SSMTarget, tunnel.Region = environment.SSMTarget, environment.Region
 	}
-	if data, ok := secret.Data["shared"]; ok {
-		configPath := core.GetConfigPath(core.ConfigSharedFile)
-		exists, err := utils.PathExists(configPath)
-		if err != nil {
-			return err
-		}
-		if exists {
-			err = utils.Copy(configPath, configPath+".bak")
-			if err != nil {
-				return err
-			}
-		}
-		return ioutil.WriteFile(
-			configPath,
-			[]byte(data.(string)),
-			0600,
-		)
-	} else {
-		return errors.New("no shared config found")
+	if err := tunnel.Connect(); err != nil {
+		return nil, err
 	}
+	return &tunnel, nil
 }
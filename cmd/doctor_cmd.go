@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/benchlabs/bub/core"
+	"github.com/benchlabs/bub/integrations/atlassian"
+	"github.com/benchlabs/bub/integrations/aws"
+	"github.com/benchlabs/bub/integrations/ci"
+	"github.com/benchlabs/bub/integrations/github"
+	"github.com/benchlabs/bub/integrations/vault"
+	"github.com/benchlabs/bub/utils"
+	"github.com/benchlabs/bub/utils/ssh"
+	"github.com/urfave/cli"
+)
+
+const (
+	statusOK   = "OK"
+	statusFail = "FAIL"
+	statusSkip = "SKIP"
+)
+
+type diagnostic struct {
+	Check, Target, Status, Detail, Hint string
+	Latency                             time.Duration
+}
+
+type diagnosticFn func() (detail string, err error)
+
+type check struct {
+	name, target, hint string
+	// skip is set when the integration is not configured.
+	skip string
+	fn   diagnosticFn
+}
+
+func buildDoctorCmd(cfg *core.Configuration) cli.Command {
+	return cli.Command{
+		Name:  "doctor",
+		Usage: "Check the configuration and the connectivity of every integration.",
+		Action: func(c *cli.Context) error {
+			diagnostics := runChecks(doctorChecks(cfg))
+			printDiagnostics(diagnostics)
+			for _, d := range diagnostics {
+				if d.Status == statusFail {
+					return cli.NewExitError("", 1)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func doctorChecks(cfg *core.Configuration) []check {
+	checks := []check{
+		{
+			name: "config", target: core.ConfigUserFile,
+			hint: "Fix the YAML syntax with 'bub config'.",
+			fn: func() (string, error) {
+				return "", core.ValidateConfigurationFile(core.ConfigUserFile)
+			},
+		},
+		{
+			name: "config", target: core.ConfigSharedFile,
+			hint: "Run 'bub config --load-shared-config' or fix it with 'bub config --shared'.",
+			fn: func() (string, error) {
+				err := core.ValidateConfigurationFile(core.ConfigSharedFile)
+				if err == nil {
+					_, err = core.LoadConfiguration()
+				}
+				return "merged with " + core.ConfigUserFile, err
+			},
+		},
+	}
+
+	for _, region := range cfg.AWS.Regions {
+		region := region
+		checks = append(checks, check{
+			name: "aws", target: region,
+			hint: "Check ~/.aws/credentials or run 'bub setup'.",
+			fn: func() (string, error) {
+				return aws.CheckCredentials(region)
+			},
+		})
+	}
+
+	for _, e := range cfg.AWS.Environments {
+		e := e
+		target := e.Prefix
+		if target == "" {
+			target = "(default)"
+		}
+		c := check{
+			name: "jumphost", target: target,
+			hint: "Check your VPN, ssh-agent and '~/.ssh/config' for " + e.JumpHost + ".",
+			fn: func() (string, error) {
+				return e.JumpHost, ssh.CheckHost(e.JumpHost, cfg.Ssh.ConnectTimeout)
+			},
+		}
+		if e.JumpHost == "" {
+			c.skip = "no jumphost configured"
+		}
+		checks = append(checks, c)
+	}
+
+	vaultCheck := check{
+		name: "vault", target: cfg.Vault.Server,
+		hint: "Run 'bub config --load-shared-config' to log into Vault again.",
+		fn: func() (string, error) {
+			tunnel, err := prepareTunnel(cfg, "dev")
+			if err != nil {
+				return "", err
+			}
+			defer tunnel.Close()
+			return "token valid", vault.CheckToken(cfg, tunnel)
+		},
+	}
+	if cfg.Vault.Server == "" {
+		vaultCheck.skip = "server not configured"
+	}
+	checks = append(checks, vaultCheck)
+
+	checks = append(checks, []check{
+		{
+			name: "github", target: cfg.GitHub.Organization,
+			hint: "Create a token with the 'repo' scope and run 'bub setup --reset-credentials'.",
+			fn: func() (string, error) {
+				scopes, err := github.CheckGitHub(cfg)
+				if err != nil {
+					return "", err
+				}
+				if !utils.Contains("repo", scopes...) {
+					return "scopes: " + strings.Join(scopes, ", "), fmt.Errorf("the 'repo' scope is missing")
+				}
+				return "scopes: " + strings.Join(scopes, ", "), nil
+			},
+		},
+		{
+			name: "jira", target: cfg.JIRA.Server, skip: skipIfEmpty(cfg.JIRA.Server),
+			hint: "Run 'BUB_UPDATE_CREDENTIALS=1 bub setup' to re-enter your JIRA credentials.",
+			fn: func() (string, error) {
+				return cfg.JIRA.Username, atlassian.CheckJIRA(cfg)
+			},
+		},
+		{
+			name: "confluence", target: cfg.Confluence.Server, skip: skipIfEmpty(cfg.Confluence.Server),
+			hint: "Run 'BUB_UPDATE_CREDENTIALS=1 bub setup' to re-enter your Confluence credentials.",
+			fn: func() (string, error) {
+				return cfg.Confluence.Username, atlassian.CheckConfluence(cfg)
+			},
+		},
+		{
+			name: "jenkins", target: cfg.Jenkins.Server, skip: skipIfEmpty(cfg.Jenkins.Server),
+			hint: "Use your Jenkins API token as password, run 'BUB_UPDATE_CREDENTIALS=1 bub setup'.",
+			fn: func() (string, error) {
+				return cfg.Jenkins.Username, ci.CheckJenkins(cfg)
+			},
+		},
+		{
+			name: "circle", target: "circleci.com",
+			hint: "Set 'circle.token' with 'bub config' or the CIRCLE_TOKEN environment variable.",
+			fn: func() (string, error) {
+				return ci.CheckCircle(cfg)
+			},
+		},
+	}...)
+
+	required := [][]string{{"git"}, {"ssh"}, {"pgcli", "psql"}, {"mycli", "mysql"}}
+	for _, e := range cfg.AWS.Environments {
+		if e.UsesSSM() {
+			required = append(required, []string{"aws"}, []string{"session-manager-plugin"})
+			break
+		}
+	}
+	for _, binaries := range required {
+		binaries := binaries
+		checks = append(checks, check{
+			name: "binary", target: strings.Join(binaries, "/"),
+			hint: "Install " + strings.Join(binaries, " or ") + " and make sure it is in your PATH.",
+			fn: func() (string, error) {
+				return lookPathAny(binaries...)
+			},
+		})
+	}
+	return checks
+}
+
+func runChecks(checks []check) []diagnostic {
+	diagnostics := make([]diagnostic, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		d := diagnostic{Check: c.name, Target: c.target}
+		if c.skip != "" {
+			d.Status = statusSkip
+			d.Detail = c.skip
+			diagnostics[i] = d
+			continue
+		}
+		wg.Add(1)
+		go func(i int, c check, d diagnostic) {
+			defer wg.Done()
+			start := time.Now()
+			detail, err := c.fn()
+			d.Latency = time.Since(start)
+			d.Detail = detail
+			d.Status = statusOK
+			if err != nil {
+				d.Status = statusFail
+				d.Detail = strings.TrimSpace(strings.Split(err.Error(), "\n")[0])
+				d.Hint = c.hint
+			}
+			diagnostics[i] = d
+		}(i, c, d)
+	}
+	wg.Wait()
+	return diagnostics
+}
+
+func printDiagnostics(diagnostics []diagnostic) {
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "Check\tTarget\tStatus\tLatency\tDetail\tHint")
+	for _, d := range diagnostics {
+		latency := ""
+		if d.Latency > 0 {
+			latency = fmt.Sprintf("%dms", d.Latency.Nanoseconds()/int64(time.Millisecond))
+		}
+		row := []string{d.Check, d.Target, d.Status, latency, d.Detail, d.Hint}
+		fmt.Fprintln(table, strings.Join(row, "\t"))
+	}
+	table.Flush()
+}
+
+func lookPathAny(binaries ...string) (string, error) {
+	for _, b := range binaries {
+		if p, err := exec.LookPath(b); err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found", strings.Join(binaries, ", "))
+}
+
+func skipIfEmpty(server string) string {
+	if server == "" {
+		return "server not configured"
+	}
+	return ""
+}
@@ -5,8 +5,21 @@ import (
 	"github.com/benchlabs/bub/core"
 	"github.com/benchlabs/bub/integrations/github"
 	"github.com/urfave/cli"
+	"strconv"
 )
 
+// parseRunID returns 0 without argument, for the runs of the current commit.
+func parseRunID(c *cli.Context) (int64, error) {
+	if c.NArg() == 0 {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(c.Args().First(), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid run ID '%s'", c.Args().First())
+	}
+	return id, nil
+}
+
 func buildGitHubCmds(cfg *core.Configuration, manifest *core.Manifest) []cli.Command {
 	maxAge := "max-age"
 	closed := "closed"
@@ -79,6 +92,106 @@ func buildGitHubCmds(cfg *core.Configuration, manifest *core.Manifest) []cli.Com
 				return github.MustInitGitHub(cfg).ListBranches(c.Int(maxAge))
 			},
 		},
+		{
+			Name:  "runs",
+			Usage: "List the GitHub Actions runs of the current branch.",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "branch", Usage: "Branch of the runs, the current one by default."},
+				cli.StringFlag{Name: "commit", Usage: "Commit of the runs, e.g. HEAD."},
+				cli.IntFlag{Name: "limit", Value: 20, Usage: "Maximum number of runs."},
+			},
+			Action: func(c *cli.Context) error {
+				commit := c.String("commit")
+				if commit == "HEAD" {
+					head, err := core.MustInitGit(".").CurrentHEAD()
+					if err != nil {
+						return err
+					}
+					commit = head
+				}
+				params := github.RunsParams{Branch: c.String("branch"), Commit: commit, Limit: c.Int("limit")}
+				return github.MustInitGitHub(cfg).ListRuns(manifest, params)
+			},
+			Subcommands: []cli.Command{
+				{
+					Name:      "watch",
+					Aliases:   []string{"w"},
+					Usage:     "Follow the run, or the runs of the current commit, exits with 1 unless they succeed.",
+					ArgsUsage: "[RUN]",
+					Action: func(c *cli.Context) error {
+						id, err := parseRunID(c)
+						if err != nil {
+							return err
+						}
+						return github.MustInitGitHub(cfg).WatchRuns(manifest, id)
+					},
+				},
+				{
+					Name:      "rerun",
+					Aliases:   []string{"r"},
+					Usage:     "Rerun the failed jobs of the run, or of the failed runs of the current commit.",
+					ArgsUsage: "[RUN]",
+					Flags: []cli.Flag{
+						cli.BoolFlag{Name: "all", Usage: "Rerun all the jobs."},
+						cli.BoolFlag{Name: "no-wait", Usage: "Do not follow the runs."},
+					},
+					Action: func(c *cli.Context) error {
+						id, err := parseRunID(c)
+						if err != nil {
+							return err
+						}
+						return github.MustInitGitHub(cfg).RerunRuns(manifest, id, c.Bool("all"), c.Bool("no-wait"))
+					},
+				},
+				{
+					Name:      "artifacts",
+					Aliases:   []string{"a"},
+					Usage:     "Download the artifacts of the run, or of the runs of the current commit.",
+					ArgsUsage: "[PATTERN]...",
+					Flags:     append([]cli.Flag{cli.Int64Flag{Name: "run", Usage: "ID of the run."}}, artifactFlags()...),
+					Action: func(c *cli.Context) error {
+						return github.MustInitGitHub(cfg).DownloadRunArtifacts(manifest, c.Int64("run"), getArtifactParams(c))
+					},
+				},
+				{
+					Name:      "logs",
+					Aliases:   []string{"l"},
+					Usage:     "Show the log of the failed steps of the run, or of the runs of the current commit.",
+					ArgsUsage: "[RUN]",
+					Flags: []cli.Flag{
+						cli.StringFlag{Name: "job", Usage: "Show the log of the job, even if it succeeded."},
+					},
+					Action: func(c *cli.Context) error {
+						id, err := parseRunID(c)
+						if err != nil {
+							return err
+						}
+						return github.MustInitGitHub(cfg).ShowRunLogs(manifest, id, c.String("job"))
+					},
+				},
+			},
+		},
+		{
+			Name:    "status",
+			Aliases: []string{"st"},
+			Usage:   "Dashboard of your open PRs and review requests across the organization.",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: role, Usage: "Only 'author' or 'review-requested'. Default: both"},
+				cli.StringFlag{Name: "repo", Usage: "Only the PRs of the repository."},
+				cli.StringFlag{Name: "checks", Usage: "Only the PRs with the combined check state, e.g. 'failure' or 'pending'."},
+				cli.BoolFlag{Name: "ready", Usage: "Only the approved, green and mergeable PRs."},
+				cli.BoolFlag{Name: "json", Usage: "Print the PRs as JSON."},
+			},
+			Action: func(c *cli.Context) error {
+				return github.MustInitGitHub(cfg).ShowPRStatuses(github.PRStatusParams{
+					Role:       c.String(role),
+					Repository: c.String("repo"),
+					Checks:     c.String("checks"),
+					Ready:      c.Bool("ready"),
+					JSON:       c.Bool("json"),
+				})
+			},
+		},
 		{
 			Name:  "list-reviewers",
 			Usage: "List reviewer based on the current changes.",
@@ -1,12 +1,32 @@
 package cmd
 
 import (
+	"fmt"
 	"github.com/benchlabs/bub/core"
 	"github.com/benchlabs/bub/integrations/ci"
 	"github.com/urfave/cli"
+	"strconv"
+	"strings"
 )
 
+// parseBuildNumber returns 0, the last build, when empty.
+func parseBuildNumber(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	number, err := strconv.ParseInt(strings.TrimPrefix(value, "#"), 10, 64)
+	if err != nil || number <= 0 {
+		return 0, cli.NewExitError(fmt.Sprintf("Invalid build number '%s'.", value), 1)
+	}
+	return number, nil
+}
+
 func buildJenkinsCmds(cfg *core.Configuration, manifest *core.Manifest) []cli.Command {
+	branchFlag := cli.StringFlag{Name: "branch", Usage: "Branch of the multibranch project, the current one by default."}
+	getJenkins := func(c *cli.Context) *ci.Jenkins {
+		return ci.MustInitJenkins(cfg, manifest).WithBranch(c.String("branch"))
+	}
+
 	return []cli.Command{
 		{
 			Name:    "master",
@@ -17,28 +37,93 @@ func buildJenkinsCmds(cfg *core.Configuration, manifest *core.Manifest) []cli.Co
 			},
 		},
 		{
-			Name:    "console",
-			Aliases: []string{"c"},
-			Usage:   "Opens the (web) console of the last build of main branch.",
+			Name:      "console",
+			Aliases:   []string{"c"},
+			Usage:     "Opens the (web) console of the last or a given build.",
+			ArgsUsage: "[BUILD]",
+			Flags:     []cli.Flag{branchFlag},
+			Action: func(c *cli.Context) error {
+				build, err := parseBuildNumber(c.Args().First())
+				if err != nil {
+					return err
+				}
+				page := "lastBuild/consoleFull"
+				if build != 0 {
+					page = fmt.Sprintf("%d/consoleFull", build)
+				}
+				return getJenkins(c).OpenPage(page)
+			},
+		},
+		{
+			Name:      "jobs",
+			Aliases:   []string{"j"},
+			Usage:     "Streams the console of the queued, last or given build, exits with 1 on FAILURE, 2 UNSTABLE, 3 ABORTED.",
+			ArgsUsage: "[BUILD]",
+			Flags:     []cli.Flag{branchFlag},
+			Action: func(c *cli.Context) error {
+				build, err := parseBuildNumber(c.Args().First())
+				if err != nil {
+					return err
+				}
+				return getJenkins(c).ShowConsoleOutput(build)
+			},
+		},
+		{
+			Name:    "history",
+			Aliases: []string{"h"},
+			Usage:   "List the recent builds with their result, duration, commit and cause.",
+			Flags: []cli.Flag{
+				branchFlag,
+				cli.IntFlag{Name: "limit", Value: 20, Usage: "Number of builds."},
+			},
+			Action: func(c *cli.Context) error {
+				return getJenkins(c).ShowHistory(c.Int("limit"))
+			},
+		},
+		{
+			Name:      "diff",
+			Aliases:   []string{"d"},
+			Usage:     "List the commits built after the first build, up to the second.",
+			ArgsUsage: "FROM TO",
+			Flags:     []cli.Flag{branchFlag},
 			Action: func(c *cli.Context) error {
-				return ci.MustInitJenkins(cfg, manifest).OpenPage("lastBuild/consoleFull")
+				if c.NArg() != 2 {
+					return cli.NewExitError("Two build numbers are required.", 1)
+				}
+				from, err := parseBuildNumber(c.Args().Get(0))
+				if err != nil {
+					return err
+				}
+				to, err := parseBuildNumber(c.Args().Get(1))
+				if err != nil {
+					return err
+				}
+				return getJenkins(c).DiffBuilds(from, to)
 			},
 		},
 		{
-			Name:    "jobs",
-			Aliases: []string{"j"},
-			Usage:   "Shows the console output of the last build.",
+			Name:    "failing",
+			Aliases: []string{"f"},
+			Usage:   "List the branches whose last build failed.",
 			Action: func(c *cli.Context) error {
-				ci.MustInitJenkins(cfg, manifest).ShowConsoleOutput()
-				return nil
+				return ci.MustInitJenkins(cfg, manifest).ShowFailingBranches()
 			},
 		},
 		{
-			Name:    "artifacts",
-			Aliases: []string{"a"},
-			Usage:   "Get the previous build's artifacts.",
+			Name:      "artifacts",
+			Aliases:   []string{"a"},
+			Usage:     "Get the artifacts of the last or a given build matching the glob patterns, all by default. The checksums come from the fingerprints, only the freestyle jobs record them, the artifacts of the pipeline and multibranch builds are only checked by size.",
+			ArgsUsage: "[PATTERN ...]",
+			Flags: append([]cli.Flag{
+				branchFlag,
+				cli.StringFlag{Name: "build", Usage: "Build number, the last one by default."},
+			}, artifactFlags()...),
 			Action: func(c *cli.Context) error {
-				return ci.MustInitJenkins(cfg, manifest).GetArtifacts()
+				build, err := parseBuildNumber(c.String("build"))
+				if err != nil {
+					return err
+				}
+				return getJenkins(c).GetArtifacts(build, getArtifactParams(c))
 			},
 		},
 		{
@@ -47,11 +132,49 @@ func buildJenkinsCmds(cfg *core.Configuration, manifest *core.Manifest) []cli.Co
 			Flags: []cli.Flag{
 				cli.BoolFlag{Name: "no-wait", Usage: "Do not wait for the job to be completed."},
 				cli.BoolFlag{Name: "force", Usage: "Trigger job regardless if a build running."},
+				cli.StringSliceFlag{Name: "param, p", Usage: "Build parameter, e.g. -p SUITE=smoke, can be repeated."},
+				cli.StringFlag{Name: "preset", Usage: "Named parameters from the manifest or the config."},
+			},
+			Usage: "Trigger build of the current branch, prompts for the required parameters.",
+			Action: func(c *cli.Context) error {
+				params, err := ci.ParseBuildParameters(c.StringSlice("param"))
+				if err != nil {
+					return err
+				}
+				return ci.MustInitJenkins(cfg, manifest).BuildJob(ci.BuildParams{
+					Async:      c.Bool("no-wait"),
+					Force:      c.Bool("force"),
+					Preset:     c.String("preset"),
+					Parameters: params,
+				})
 			},
-			Usage: "Trigger build of the current branch.",
+		},
+		{
+			Name:      "tests",
+			Aliases:   []string{"t"},
+			Usage:     "Show the failed tests of the last or a given build, new or recurring since the previous build.",
+			ArgsUsage: "[BUILD]",
+			Flags: []cli.Flag{
+				branchFlag,
+				cli.StringFlag{Name: "format", Value: "text", Usage: "text, json or junit (XML)."},
+			},
+			Action: func(c *cli.Context) error {
+				build, err := parseBuildNumber(c.Args().First())
+				if err != nil {
+					return err
+				}
+				return getJenkins(c).ShowTestReport(ci.TestReportParams{
+					Build:  build,
+					Format: c.String("format"),
+				})
+			},
+		},
+		{
+			Name:    "params",
+			Aliases: []string{"p"},
+			Usage:   "List the parameters of the job.",
 			Action: func(c *cli.Context) error {
-				ci.MustInitJenkins(cfg, manifest).BuildJob(c.Bool("no-wait"), c.Bool("force"))
-				return nil
+				return ci.MustInitJenkins(cfg, manifest).ListParameters()
 			},
 		},
 	}
@@ -1,10 +1,9 @@
 package cmd
 
 import (
-	"fmt"
 	"log"
 	"os"
-	"path"
+	"strconv"
 
 	"github.com/benchlabs/bub/core"
 	"github.com/benchlabs/bub/integrations"
@@ -46,56 +45,91 @@ func buildCircleCmds(cfg *core.Configuration, manifest *core.Manifest) []cli.Com
 	return []cli.Command{
 		{
 			Name:    "trigger",
-			Usage:   "Trigger the current branch of the current repo and wait for success.",
+			Usage:   "Trigger a pipeline on the current branch and wait for all its workflows.",
 			Aliases: []string{"t"},
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "branch", Usage: "Branch, the current one by default."},
+				cli.StringSliceFlag{Name: "param, p", Usage: "Pipeline parameter, e.g. -p deploy=true, can be repeated."},
+				cli.BoolFlag{Name: "no-wait", Usage: "Do not wait for the workflows to be completed."},
+			},
 			Action: func(c *cli.Context) error {
-				return ci.MustInitCircle(cfg).TriggerAndWaitForSuccess(manifest)
+				params, err := ci.ParseBuildParameters(c.StringSlice("param"))
+				if err != nil {
+					return err
+				}
+				return ci.MustInitCircle(cfg).TriggerPipeline(manifest, ci.PipelineParams{
+					Branch:     c.String("branch"),
+					Parameters: params,
+					Async:      c.Bool("no-wait"),
+				})
+			},
+		},
+		{
+			Name:      "status",
+			Usage:     "Show the workflows and the jobs of the current commit's pipeline, or of the given pipeline number.",
+			ArgsUsage: "[PIPELINE]",
+			Aliases:   []string{"s"},
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "wait, w", Usage: "Wait for the workflows to be completed."},
+			},
+			Action: func(c *cli.Context) error {
+				var number int64
+				if c.NArg() > 0 {
+					n, err := strconv.ParseInt(c.Args().First(), 10, 64)
+					if err != nil {
+						return cli.NewExitError("The pipeline must be a number.", 1)
+					}
+					number = n
+				}
+				return ci.MustInitCircle(cfg).ShowPipeline(manifest, number, c.Bool("wait"))
+			},
+		},
+		{
+			Name:    "rerun",
+			Usage:   "Rerun the failed workflows of the current commit and wait for them.",
+			Aliases: []string{"r"},
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "workflow", Usage: "Name of the workflow to rerun, even if it did not fail."},
+				cli.BoolFlag{Name: "failed-only", Usage: "Only rerun the failed jobs."},
+				cli.BoolFlag{Name: "from-failed", Usage: "Rerun the failed jobs and the ones depending on them."},
+				cli.BoolFlag{Name: "no-wait", Usage: "Do not wait for the workflows to be completed."},
+			},
+			Action: func(c *cli.Context) error {
+				return ci.MustInitCircle(cfg).RerunWorkflows(manifest, ci.RerunParams{
+					Workflow:   c.String("workflow"),
+					FailedOnly: c.Bool("failed-only"),
+					FromFailed: c.Bool("from-failed"),
+					Async:      c.Bool("no-wait"),
+				})
+			},
+		},
+		{
+			Name:      "approve",
+			Usage:     "Approve the job on hold of the current commit and wait for the workflow.",
+			ArgsUsage: "[JOB]",
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "no-wait", Usage: "Do not wait for the workflow to be completed."},
+			},
+			Action: func(c *cli.Context) error {
+				return ci.MustInitCircle(cfg).ApproveJob(manifest, c.Args().First(), c.Bool("no-wait"))
 			},
 		},
 		{
 			Name:    "check",
-			Usage:   "Check the build status of the current commit.",
+			Usage:   "Wait for the pipeline of the current commit and check its workflows.",
 			Aliases: []string{"c"},
 			Action: func(c *cli.Context) error {
 				return ci.MustInitCircle(cfg).CheckBuildStatus(manifest)
 			},
 		},
 		{
-			Name:    "artifact",
-			Usage:   "Get the build artifact of the current commit. You need to provide the artifact file name as an argument.",
-			Aliases: []string{"a"},
+			Name:      "artifact",
+			Usage:     "Get the build artifacts of the current commit matching the glob patterns, all by default.",
+			ArgsUsage: "[PATTERN ...]",
+			Aliases:   []string{"a"},
+			Flags:     artifactFlags(),
 			Action: func(c *cli.Context) error {
-				var dir string
-
-				if c.NArg() < 1 {
-					return fmt.Errorf("You have too few arguments. Please provide the name of the artifact you want to download.")
-				}
-				if c.NArg() > 1 {
-					return fmt.Errorf("You have too many arguments. The only argument accepted is the name of the artifact you want to download.")
-				}
-
-				fname := c.Args().Get(0)
-				dir = c.String("path")
-				if len(dir) == 0 {
-					d, err := os.Getwd()
-					if err != nil {
-						return err
-					}
-					dir = d
-				}
-
-				_, err := os.Stat(dir)
-				if err != nil {
-					return err
-				}
-
-				return ci.MustInitCircle(cfg).DownloadArtifact(manifest, fname, path.Join(dir, fname))
-			},
-			Flags: []cli.Flag{
-				cli.StringFlag{
-					Name:  "path, p",
-					Usage: "path to download the artifact to",
-				},
+				return ci.MustInitCircle(cfg).GetArtifacts(manifest, getArtifactParams(c))
 			},
 		},
 		{
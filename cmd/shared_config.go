@@ -0,0 +1,479 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/benchlabs/bub/core"
+	"github.com/benchlabs/bub/integrations/vault"
+	"github.com/benchlabs/bub/utils"
+	"github.com/benchlabs/bub/utils/ssh"
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// content of the shared config as of the last load, used to detect and merge remote changes.
+	sharedConfigBaseFile    = core.ConfigSharedFile + ".base"
+	sharedConfigVersionFile = core.ConfigSharedFile + ".version"
+)
+
+type sharedConfigVersion struct {
+	Version         int
+	Author, Updated string
+	Content         string
+}
+
+// secretStore is implemented by the Vault client.
+type secretStore interface {
+	Read(path string) (*api.Secret, error)
+	Write(path string, data map[string]interface{}) (*api.Secret, error)
+	List(path string) (*api.Secret, error)
+}
+
+type sharedConfigStore struct {
+	cfg     *core.Configuration
+	tunnel  *ssh.Connection
+	secrets secretStore
+}
+
+// openSharedConfigStore and getConfigPath are replaced in the tests.
+var (
+	openSharedConfigStore = initSharedConfigStore
+	getConfigPath         = core.GetConfigPath
+)
+
+func initSharedConfigStore(cfg *core.Configuration) (*sharedConfigStore, error) {
+	if cfg.Vault.Path == "" {
+		return nil, errors.New("the path configuration for vault is missing")
+	}
+	tunnel, err := prepareTunnel(cfg, "dev")
+	if err != nil {
+		return nil, err
+	}
+	return &sharedConfigStore{cfg: cfg, tunnel: tunnel, secrets: vault.MustInitVault(cfg, tunnel)}, nil
+}
+
+func (s *sharedConfigStore) Close() error {
+	if s.tunnel == nil {
+		return nil
+	}
+	return s.tunnel.Close()
+}
+
+func (s *sharedConfigStore) historyPath(version int) string {
+	return path.Join(s.cfg.Vault.Path, "history", strconv.Itoa(version))
+}
+
+func (s *sharedConfigStore) read(secretPath string) (*sharedConfigVersion, error) {
+	secret, err := s.secrets.Read(secretPath)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, nil
+	}
+	v := &sharedConfigVersion{}
+	if data, ok := secret.Data["shared"]; ok {
+		v.Content = data.(string)
+	} else {
+		return nil, errors.New("no shared config found")
+	}
+	// configs stored before versioning was introduced are version 0.
+	if data, ok := secret.Data["version"]; ok {
+		v.Version, err = strconv.Atoi(fmt.Sprintf("%v", data))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if data, ok := secret.Data["author"]; ok {
+		v.Author = data.(string)
+	}
+	if data, ok := secret.Data["updated"]; ok {
+		v.Updated = data.(string)
+	}
+	return v, nil
+}
+
+func (s *sharedConfigStore) Current() (*sharedConfigVersion, error) {
+	v, err := s.read(s.cfg.Vault.Path)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return &sharedConfigVersion{}, nil
+	}
+	return v, nil
+}
+
+func (s *sharedConfigStore) Get(version int) (*sharedConfigVersion, error) {
+	v, err := s.read(s.historyPath(version))
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, fmt.Errorf("version %d not found", version)
+	}
+	return v, nil
+}
+
+func (s *sharedConfigStore) History() ([]*sharedConfigVersion, error) {
+	secret, err := s.secrets.List(path.Join(s.cfg.Vault.Path, "history"))
+	if err != nil {
+		return nil, err
+	}
+	var versions []*sharedConfigVersion
+	if secret == nil {
+		return versions, nil
+	}
+	keys, _ := secret.Data["keys"].([]interface{})
+	for _, k := range keys {
+		version, err := strconv.Atoi(fmt.Sprintf("%v", k))
+		if err != nil {
+			continue
+		}
+		v, err := s.Get(version)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version > versions[j].Version })
+	return versions, nil
+}
+
+// getSharedConfigAuthor falls back on the GitHub and the system users, there is no Vault username with a token.
+func getSharedConfigAuthor(cfg *core.Configuration) string {
+	for _, name := range []string{cfg.Vault.Username, cfg.GitHub.Username, os.Getenv("USER")} {
+		if name != "" {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+func newSharedConfigConflictError(remote *sharedConfigVersion, base int) error {
+	return fmt.Errorf("the shared config was changed remotely since you loaded it "+
+		"(version %d by %s on %s, you have version %d). "+
+		"Run 'bub config --shared-diff' and 'bub config --merge-shared-config' first",
+		remote.Version, remote.Author, remote.Updated, base)
+}
+
+// writeAndCheck reads the secret back, another store at the same time may have replaced it.
+func (s *sharedConfigStore) writeAndCheck(secretPath string, v *sharedConfigVersion, previous int) error {
+	payload := map[string]interface{}{
+		"shared":  v.Content,
+		"version": strconv.Itoa(v.Version),
+		"author":  v.Author,
+		"updated": v.Updated,
+	}
+	if _, err := s.secrets.Write(secretPath, payload); err != nil {
+		return err
+	}
+	stored, err := s.read(secretPath)
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		return fmt.Errorf("%s is missing after the write", secretPath)
+	}
+	if *stored != *v {
+		return newSharedConfigConflictError(stored, previous)
+	}
+	return nil
+}
+
+// Put stores the content as the next version, both as the current config and in the history.
+// The KV store has no check-and-set: the version is claimed in the history first, and the writes are read back.
+func (s *sharedConfigStore) Put(previous int, content string) (*sharedConfigVersion, error) {
+	v := &sharedConfigVersion{
+		Version: previous + 1,
+		Author:  getSharedConfigAuthor(s.cfg),
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Content: content,
+	}
+	existing, err := s.read(s.historyPath(v.Version))
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, newSharedConfigConflictError(existing, previous)
+	}
+	if err = s.writeAndCheck(s.historyPath(v.Version), v, previous); err != nil {
+		return nil, err
+	}
+	if err = s.writeAndCheck(s.cfg.Vault.Path, v, previous); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func readSharedConfigBase() (version int, content string, err error) {
+	data, err := ioutil.ReadFile(getConfigPath(sharedConfigVersionFile))
+	if os.IsNotExist(err) {
+		return 0, "", nil
+	} else if err != nil {
+		return 0, "", err
+	}
+	version, err = strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, "", err
+	}
+	data, err = ioutil.ReadFile(getConfigPath(sharedConfigBaseFile))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, "", err
+	}
+	return version, string(data), nil
+}
+
+func writeSharedConfigBase(v *sharedConfigVersion) error {
+	err := ioutil.WriteFile(getConfigPath(sharedConfigBaseFile), []byte(v.Content), 0600)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(getConfigPath(sharedConfigVersionFile), []byte(strconv.Itoa(v.Version)), 0600)
+}
+
+func readLocalSharedConfig() (string, error) {
+	data, err := ioutil.ReadFile(getConfigPath(core.ConfigSharedFile))
+	return string(data), err
+}
+
+// writeLocalSharedConfig keeps a timestamped backup of the previous shared config.
+func writeLocalSharedConfig(content string) error {
+	configPath := getConfigPath(core.ConfigSharedFile)
+	exists, err := utils.PathExists(configPath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		backup := configPath + "." + utils.CurrentTimeForFilename() + ".bak"
+		if err = utils.Copy(configPath, backup); err != nil {
+			return err
+		}
+		log.Printf("Previous shared config saved to %s", backup)
+	}
+	return ioutil.WriteFile(configPath, []byte(content), 0600)
+}
+
+func storeSharedConfig(cfg *core.Configuration) error {
+	if !utils.AskForConfirmation("Store the shared config to Vault?") {
+		log.Print("Aborting...")
+		return nil
+	}
+	store, err := openSharedConfigStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	content, err := readLocalSharedConfig()
+	if err != nil {
+		return err
+	}
+	remote, err := store.Current()
+	if err != nil {
+		return err
+	}
+	if remote.Content == content {
+		log.Printf("The shared config is already up to date (version %d).", remote.Version)
+		return writeSharedConfigBase(remote)
+	}
+	base, _, err := readSharedConfigBase()
+	if err != nil {
+		return err
+	}
+	if base != remote.Version {
+		return newSharedConfigConflictError(remote, base)
+	}
+
+	v, err := store.Put(remote.Version, content)
+	if err != nil {
+		return err
+	}
+	log.Printf("The shared config has been updated to version %d.", v.Version)
+	return writeSharedConfigBase(v)
+}
+
+func loadSharedConfig(cfg *core.Configuration) error {
+	store, err := openSharedConfigStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	remote, err := store.Current()
+	if err != nil {
+		return err
+	}
+	if remote.Content == "" {
+		return errors.New("no shared config found")
+	}
+	if err = writeLocalSharedConfig(remote.Content); err != nil {
+		return err
+	}
+	log.Printf("Loaded version %d of the shared config.", remote.Version)
+	return writeSharedConfigBase(remote)
+}
+
+// mergeSharedConfig does a three-way merge between the version last loaded,
+// the local changes and the remote changes.
+func mergeSharedConfig(cfg *core.Configuration) error {
+	store, err := openSharedConfigStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	remote, err := store.Current()
+	if err != nil {
+		return err
+	}
+	_, baseContent, err := readSharedConfigBase()
+	if err != nil {
+		return err
+	}
+	basePath, err := writeTempFile("bub-shared-base", baseContent)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(basePath)
+	remotePath, err := writeTempFile("bub-shared-remote", remote.Content)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(remotePath)
+
+	configPath := getConfigPath(core.ConfigSharedFile)
+	if err = utils.Copy(configPath, configPath+"."+utils.CurrentTimeForFilename()+".bak"); err != nil {
+		return err
+	}
+	// merge-file exits with the number of conflicts.
+	err = utils.RunCmd("git", "merge-file",
+		"-L", "local", "-L", "base", "-L", fmt.Sprintf("remote (version %d)", remote.Version),
+		configPath, basePath, remotePath)
+	if _, ok := err.(*exec.ExitError); ok {
+		log.Print("The merge has conflicts, resolve them in the editor.")
+		if err = utils.EditFile(configPath); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	// the base stays at the previous version until the conflicts are resolved, the store then still refuses.
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+	if hasConflictMarkers(string(data)) {
+		return fmt.Errorf("%s still has conflicts, resolve them and run 'bub config --merge-shared-config' again", configPath)
+	}
+	log.Printf("Merged version %d, run 'bub config --store-shared-config' to publish the result.", remote.Version)
+	return writeSharedConfigBase(remote)
+}
+
+// hasConflictMarkers is true while the file has the markers of git merge-file.
+func hasConflictMarkers(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(line, "<<<<<<< ") || strings.HasPrefix(line, ">>>>>>> ") {
+			return true
+		}
+	}
+	return false
+}
+
+func diffSharedConfig(cfg *core.Configuration) error {
+	store, err := openSharedConfigStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	remote, err := store.Current()
+	if err != nil {
+		return err
+	}
+	remotePath, err := writeTempFile("bub-shared-remote", remote.Content)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(remotePath)
+	log.Printf("Remote version %d by %s on %s.", remote.Version, remote.Author, remote.Updated)
+	err = utils.RunCmd("git", "--no-pager", "diff", "--no-index", "--", remotePath, getConfigPath(core.ConfigSharedFile))
+	// git diff exits with 1 when there are differences.
+	if _, ok := err.(*exec.ExitError); ok {
+		return nil
+	}
+	return err
+}
+
+func listSharedConfigHistory(cfg *core.Configuration) error {
+	store, err := openSharedConfigStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	versions, err := store.History()
+	if err != nil {
+		return err
+	}
+	base, _, err := readSharedConfigBase()
+	if err != nil {
+		return err
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "Version\tAuthor\tUpdated\tLoaded")
+	for _, v := range versions {
+		loaded := ""
+		if v.Version == base {
+			loaded = "*"
+		}
+		fmt.Fprintln(table, strings.Join([]string{strconv.Itoa(v.Version), v.Author, v.Updated, loaded}, "\t"))
+	}
+	return table.Flush()
+}
+
+func restoreSharedConfigVersion(cfg *core.Configuration, version int) error {
+	store, err := openSharedConfigStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	v, err := store.Get(version)
+	if err != nil {
+		return err
+	}
+	remote, err := store.Current()
+	if err != nil {
+		return err
+	}
+	if err = writeLocalSharedConfig(v.Content); err != nil {
+		return err
+	}
+	log.Printf("Restored version %d locally. Run 'bub config --store-shared-config' to publish it.", version)
+	// the restored content is stored on top of the current remote version.
+	return writeSharedConfigBase(remote)
+}
+
+func writeTempFile(prefix, content string) (string, error) {
+	f, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err = f.WriteString(content); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benchlabs/bub/core"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+type memorySecrets map[string]map[string]interface{}
+
+func (m memorySecrets) Read(secretPath string) (*api.Secret, error) {
+	data, ok := m[secretPath]
+	if !ok {
+		return nil, nil
+	}
+	return &api.Secret{Data: data}, nil
+}
+
+func (m memorySecrets) Write(secretPath string, data map[string]interface{}) (*api.Secret, error) {
+	m[secretPath] = data
+	return nil, nil
+}
+
+func (m memorySecrets) List(secretPath string) (*api.Secret, error) {
+	var keys []interface{}
+	for k := range m {
+		if strings.HasPrefix(k, secretPath+"/") {
+			keys = append(keys, strings.TrimPrefix(k, secretPath+"/"))
+		}
+	}
+	if keys == nil {
+		return nil, nil
+	}
+	return &api.Secret{Data: map[string]interface{}{"keys": keys}}, nil
+}
+
+// racingSecrets replaces the current config right after each write, like a concurrent store.
+type racingSecrets struct {
+	memorySecrets
+	path string
+}
+
+func (r racingSecrets) Write(secretPath string, data map[string]interface{}) (*api.Secret, error) {
+	r.memorySecrets[secretPath] = data
+	if secretPath == r.path {
+		r.memorySecrets[secretPath] = map[string]interface{}{"shared": "other", "version": data["version"], "author": "someone"}
+	}
+	return nil, nil
+}
+
+func sharedConfigPayload(version, content string) map[string]interface{} {
+	return map[string]interface{}{"shared": content, "version": version, "author": "someone", "updated": "2024-05-02T10:00:00Z"}
+}
+
+// setupSharedConfigTest replaces the Vault store and the config directory, the tests using it are not parallel.
+func setupSharedConfigTest(t *testing.T, secrets secretStore) (*core.Configuration, func()) {
+	dir, err := ioutil.TempDir("", "bub-shared")
+	assert.NoError(t, err)
+	cfg := &core.Configuration{}
+	cfg.Vault.Path = "secret/bub"
+	cfg.GitHub.Username = "octocat"
+	openSharedConfigStore = func(cfg *core.Configuration) (*sharedConfigStore, error) {
+		return &sharedConfigStore{cfg: cfg, secrets: secrets}, nil
+	}
+	getConfigPath = func(configFile string) string { return filepath.Join(dir, configFile) }
+	return cfg, func() {
+		openSharedConfigStore = initSharedConfigStore
+		getConfigPath = core.GetConfigPath
+		os.RemoveAll(dir)
+	}
+}
+
+func readTestFile(t *testing.T, configFile string) string {
+	data, err := ioutil.ReadFile(getConfigPath(configFile))
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func writeTestFile(t *testing.T, configFile, content string) {
+	assert.NoError(t, ioutil.WriteFile(getConfigPath(configFile), []byte(content), 0600))
+}
+
+func TestSharedConfigPut(t *testing.T) {
+	secrets := memorySecrets{}
+	cfg, teardown := setupSharedConfigTest(t, secrets)
+	defer teardown()
+	store, _ := openSharedConfigStore(cfg)
+
+	v, err := store.Put(0, "a: 1\n")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, v.Version)
+	assert.Equal(t, "octocat", v.Author)
+	current, err := store.Current()
+	assert.NoError(t, err)
+	assert.Equal(t, *v, *current)
+
+	// the version is already taken.
+	_, err = store.Put(0, "a: 2\n")
+	assert.Error(t, err)
+	history, err := store.History()
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+
+	store.secrets = racingSecrets{secrets, cfg.Vault.Path}
+	_, err = store.Put(1, "a: 3\n")
+	assert.Error(t, err)
+}
+
+func TestMergeSharedConfig(t *testing.T) {
+	secrets := memorySecrets{}
+	cfg, teardown := setupSharedConfigTest(t, secrets)
+	defer teardown()
+	// the changes are apart, git merge-file would report adjacent ones as a conflict.
+	secrets[cfg.Vault.Path] = sharedConfigPayload("2", "a: 1\nc: 1\nd: 1\nb: 2\n")
+	writeTestFile(t, sharedConfigBaseFile, "a: 1\nc: 1\nd: 1\nb: 1\n")
+	writeTestFile(t, sharedConfigVersionFile, "1")
+	writeTestFile(t, core.ConfigSharedFile, "a: 2\nc: 1\nd: 1\nb: 1\n")
+
+	assert.NoError(t, mergeSharedConfig(cfg))
+	assert.Equal(t, "a: 2\nc: 1\nd: 1\nb: 2\n", readTestFile(t, core.ConfigSharedFile))
+	assert.Equal(t, "2", readTestFile(t, sharedConfigVersionFile))
+	assert.NoError(t, diffSharedConfig(cfg))
+}
+
+func TestMergeSharedConfigConflict(t *testing.T) {
+	secrets := memorySecrets{}
+	cfg, teardown := setupSharedConfigTest(t, secrets)
+	defer teardown()
+	editor := os.Getenv("EDITOR")
+	defer os.Setenv("EDITOR", editor)
+	// the editor leaves the conflicts unresolved.
+	os.Setenv("EDITOR", "true")
+	secrets[cfg.Vault.Path] = sharedConfigPayload("2", "a: 3\n")
+	writeTestFile(t, sharedConfigBaseFile, "a: 1\n")
+	writeTestFile(t, sharedConfigVersionFile, "1")
+	writeTestFile(t, core.ConfigSharedFile, "a: 2\n")
+
+	assert.Error(t, mergeSharedConfig(cfg))
+	assert.True(t, hasConflictMarkers(readTestFile(t, core.ConfigSharedFile)))
+	assert.Equal(t, "1", readTestFile(t, sharedConfigVersionFile))
+	assert.Equal(t, "a: 1\n", readTestFile(t, sharedConfigBaseFile))
+
+	// resolved by hand, the merge then advances the base.
+	writeTestFile(t, core.ConfigSharedFile, "a: 3\n")
+	assert.NoError(t, mergeSharedConfig(cfg))
+	assert.Equal(t, "2", readTestFile(t, sharedConfigVersionFile))
+}
+
+func TestRestoreSharedConfigVersion(t *testing.T) {
+	secrets := memorySecrets{}
+	cfg, teardown := setupSharedConfigTest(t, secrets)
+	defer teardown()
+	secrets[cfg.Vault.Path] = sharedConfigPayload("2", "a: new\n")
+	secrets[cfg.Vault.Path+"/history/1"] = sharedConfigPayload("1", "a: old\n")
+	writeTestFile(t, core.ConfigSharedFile, "a: local\n")
+
+	assert.NoError(t, restoreSharedConfigVersion(cfg, 1))
+	assert.Equal(t, "a: old\n", readTestFile(t, core.ConfigSharedFile))
+	assert.Equal(t, "2", readTestFile(t, sharedConfigVersionFile))
+	assert.Equal(t, "a: new\n", readTestFile(t, sharedConfigBaseFile))
+	assert.Error(t, restoreSharedConfigVersion(cfg, 3))
+}
@@ -1,146 +1,298 @@
 package cmd
 
 import (
+	"bytes"
 	"compress/gzip"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math/big"
 	"os"
-	"regexp"
+	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/benchlabs/bub/core"
-	"github.com/benchlabs/bub/integrations/aws"
 	"github.com/benchlabs/bub/utils"
-	"github.com/mcuadros/go-version"
 	"github.com/urfave/cli"
 )
 
-type s3path struct {
-	Region, Bucket, Path string
+const (
+	manifestName    = "manifest.json"
+	updateStateFile = "update-check.json"
+	previousSuffix  = ".previous"
+)
+
+type release struct {
+	Version string `json:"version"`
+	OS      string `json:"os"`
+	Arch    string `json:"arch"`
+	// Path of the gzipped binary, relative to the root of the releases.
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// releaseManifest is published, along with its signature, for each channel
+// under '<root>/<channel>/manifest.json' and 'manifest.json.sig'.
+type releaseManifest struct {
+	Channel  string    `json:"channel"`
+	Releases []release `json:"releases"`
+}
+
+type updateState struct {
+	Checked time.Time `json:"checked"`
+	Latest  string    `json:"latest"`
 }
 
 func buildUpdateCmd(cfg *core.Configuration) cli.Command {
+	channel := "channel"
+	rollback := "rollback"
+	check := "check"
 	return cli.Command{
 		Name:  "update",
 		Usage: "Update the bub command to the latest release.",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: channel, Usage: "Release channel, 'stable' or 'beta'. Defaults to the configured channel."},
+			cli.BoolFlag{Name: rollback, Usage: "Restore the version installed before the last update."},
+			cli.BoolFlag{Name: check, Usage: "Only check if a new version is available."},
+		},
 		Action: func(c *cli.Context) error {
-			path := s3path{
-				Region: cfg.Updates.Region,
-				Bucket: cfg.Updates.Bucket,
-				Path:   cfg.Updates.Prefix,
+			if c.Bool(rollback) {
+				return rollbackBub()
+			}
+			if c.String(channel) != "" {
+				cfg.Updates.Channel = c.String(channel)
+			}
+			source, err := getReleaseSource(cfg)
+			if err != nil {
+				return err
 			}
-			obj, err := latestRelease(path)
+			rel, err := source.Latest(getChannel(cfg), runtime.GOOS, runtime.GOARCH)
 			if err != nil {
 				return err
 			}
-			path.Path = *obj.Key
-			return updateBub(path)
+			if utils.CompareVersions(rel.Version, c.App.Version) <= 0 {
+				log.Printf("bub %s is the latest version on the '%s' channel.", c.App.Version, getChannel(cfg))
+				return nil
+			}
+			if c.Bool(check) {
+				log.Printf("bub %s is available, you have %s. Run 'bub update'.", rel.Version, c.App.Version)
+				return nil
+			}
+			return updateBub(source, rel)
 		},
 	}
 }
 
-func latestRelease(base s3path) (obj *s3.Object, err error) {
-	s3cfg := aws.GetAWSConfig(base.Region)
-	sess, err := session.NewSession(&s3cfg)
-	if err != nil {
-		return nil, err
+func getChannel(cfg *core.Configuration) string {
+	if cfg.Updates.Channel == "" {
+		return "stable"
+	}
+	return cfg.Updates.Channel
+}
+
+func isConfigured(value string) bool {
+	return value != "" && !strings.HasPrefix(value, "<optional")
+}
+
+// verifySignature checks the base64 encoded signature of the SHA-256 digest of data.
+// RSA (PKCS #1 v1.5) and ECDSA (ASN.1) keys are supported.
+func verifySignature(publicKey string, data, encodedSig []byte) error {
+	block, _ := pem.Decode([]byte(publicKey))
+	if block == nil {
+		return errors.New("the updates public key is not PEM encoded")
 	}
-	svc := s3.New(sess)
-	results, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{
-		Bucket: &base.Bucket,
-		Prefix: &base.Path,
-	})
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	// regex to find version numbers in pathnames
-	versionRegex, err := regexp.Compile("[0-9\\.]+")
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encodedSig)))
 	if err != nil {
-		return nil, err
+		return err
+	}
+	digest := sha256.Sum256(data)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) != nil {
+			return errors.New("invalid manifest signature")
+		}
+	case *ecdsa.PublicKey:
+		var ecSig struct{ R, S *big.Int }
+		if _, err = asn1.Unmarshal(sig, &ecSig); err != nil {
+			return err
+		}
+		if !ecdsa.Verify(k, digest[:], ecSig.R, ecSig.S) {
+			return errors.New("invalid manifest signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
 	}
+	return nil
+}
 
-	newestVersion := "0.0"
-	var newestObj *s3.Object
-	for _, obj := range results.Contents {
-		if strings.Contains(*obj.Key, runtime.GOOS) {
-			currentVersion := string(versionRegex.Find([]byte(*obj.Key)))
-			if version.CompareSimple(currentVersion, newestVersion) > 0 {
-				newestVersion = currentVersion
-				newestObj = obj
-			}
+func (m *releaseManifest) latest(goos, goarch string) (*release, error) {
+	var newest *release
+	for i, r := range m.Releases {
+		if r.OS != goos || r.Arch != goarch {
+			continue
+		}
+		if _, err := utils.ParseVersion(r.Version); err != nil {
+			continue
 		}
+		if newest == nil || utils.CompareVersions(r.Version, newest.Version) > 0 {
+			newest = &m.Releases[i]
+		}
+	}
+	if newest == nil {
+		return nil, fmt.Errorf("no release found for %s/%s on the '%s' channel", goos, goarch, m.Channel)
 	}
-	return newestObj, nil
+	return newest, nil
 }
 
-func updateBub(path s3path) error {
+func verifyChecksum(data []byte, expected string) error {
+	sum := sha256.Sum256(data)
+	actual := hex.EncodeToString(sum[:])
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("checksum mismatch, expected %s got %s", expected, actual)
+	}
+	return nil
+}
+
+func getExecutable() (string, error) {
 	exe, err := os.Executable()
 	if err != nil {
-		log.Fatalf("Could not get bub's path: %s", err)
+		return "", fmt.Errorf("could not get bub's path: %v", err)
 	}
-	log.Printf("Downloading s3://%s/%s to %s", path.Bucket, path.Path, exe)
-	s3cfg := aws.GetAWSConfig(path.Region)
-	sess, err := session.NewSession(&s3cfg)
+	return filepath.EvalSymlinks(exe)
+}
+
+func updateBub(source releaseSource, rel *release) error {
+	exe, err := getExecutable()
 	if err != nil {
 		return err
 	}
-	downloader := s3manager.NewDownloader(sess)
-
-	// dl gzipped upstream content to temp file
-	fgz, err := ioutil.TempFile("", "bub-update")
+	log.Printf("Downloading bub %s (%s/%s)", rel.Version, rel.OS, rel.Arch)
+	data, err := source.Download(rel)
 	if err != nil {
 		return err
 	}
-	defer fgz.Close()
-	defer os.Remove(fgz.Name())
-	_, err = downloader.Download(fgz, &s3.GetObjectInput{
-		Bucket: &path.Bucket,
-		Key:    &path.Path,
-	})
+	if err = verifyChecksum(data, rel.SHA256); err != nil {
+		return err
+	}
+	gzr, err := gzip.NewReader(bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
+	return installBinary(exe, gzr)
+}
 
-	// uncompress to second tempfile
-	_, err = fgz.Seek(0, io.SeekStart)
+// installBinary writes the new binary next to the executable, so the rename
+// does not cross filesystems, and keeps the current one for rollbacks.
+func installBinary(exe string, r io.Reader) error {
+	f, err := ioutil.TempFile(filepath.Dir(exe), ".bub-update")
 	if err != nil {
 		return err
 	}
-	f, err := ioutil.TempFile("", "bub-update")
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, r)
 	if err != nil {
+		f.Close()
 		return err
 	}
-	defer f.Close()
-	defer os.Remove(f.Name())
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(f.Name(), 0755); err != nil {
+		return err
+	}
+	if err = utils.Copy(exe, exe+previousSuffix); err != nil {
+		return err
+	}
+	if err = os.Chmod(exe+previousSuffix, 0755); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), exe); err != nil {
+		return err
+	}
+	log.Printf("Update complete. Run 'bub update --rollback' to restore the previous version.")
+	newVersion, err := utils.RunCmdWithStdout(exe, "--version")
+	if err != nil {
+		return err
+	}
+	log.Printf("New version: %v", newVersion)
+	return nil
+}
 
-	// transparently gunzip as we download
-	gzr, err := gzip.NewReader(fgz)
+// rollbackBub swaps the current and the previous binaries.
+func rollbackBub() error {
+	exe, err := getExecutable()
 	if err != nil {
 		return err
 	}
-	_, err = io.Copy(f, gzr)
+	previous := exe + previousSuffix
+	exists, err := utils.PathExists(previous)
 	if err != nil {
 		return err
 	}
-	if err = os.Chmod(f.Name(), 0755); err != nil {
+	if !exists {
+		return errors.New("no previous version found")
+	}
+	current := exe + ".current"
+	if err = os.Rename(exe, current); err != nil {
 		return err
 	}
-
-	if os.Rename(f.Name(), exe) != nil {
+	if err = os.Rename(previous, exe); err != nil {
 		return err
 	}
-	log.Printf("Update complete.")
-	newVersion, err := utils.RunCmdWithStdout(exe, "--version")
+	if err = os.Rename(current, previous); err != nil {
+		return err
+	}
+	restored, err := utils.RunCmdWithStdout(exe, "--version")
 	if err != nil {
 		return err
 	}
+	log.Printf("Restored: %v", restored)
+	return nil
+}
 
-	log.Printf("New version: %v", newVersion)
+// NotifyUpdate checks at most once a day for a new version, when enabled with 'updates.notify'.
+func NotifyUpdate(c *cli.Context) error {
+	cfg := configuration
+	if !cfg.Updates.Notify || c.Args().First() == "update" {
+		return nil
+	}
+	statePath := core.GetConfigPath(updateStateFile)
+	state := updateState{}
+	if data, err := ioutil.ReadFile(statePath); err == nil {
+		json.Unmarshal(data, &state)
+	}
+	if time.Since(state.Checked) < 24*time.Hour {
+		return nil
+	}
+	state.Checked = time.Now()
+	if source, err := getReleaseSource(cfg); err == nil {
+		if rel, err := source.Latest(getChannel(cfg), runtime.GOOS, runtime.GOARCH); err == nil {
+			state.Latest = rel.Version
+		}
+	}
+	if data, err := json.Marshal(state); err == nil {
+		ioutil.WriteFile(statePath, data, 0600)
+	}
+	if state.Latest != "" && utils.CompareVersions(state.Latest, c.App.Version) > 0 {
+		log.Printf("bub %s is available, you have %s. Run 'bub update'.", state.Latest, c.App.Version)
+	}
 	return nil
 }
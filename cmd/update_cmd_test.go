@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/benchlabs/bub/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifySignature(t *testing.T) {
+	t.Parallel()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(t, err)
+	publicKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	data := []byte(`{"channel": "stable"}`)
+	digest := sha256.Sum256(data)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	assert.NoError(t, err)
+	sig, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	assert.NoError(t, err)
+	encoded := []byte(base64.StdEncoding.EncodeToString(sig))
+
+	assert.NoError(t, verifySignature(publicKey, data, encoded))
+	assert.Error(t, verifySignature(publicKey, []byte(`{"channel": "beta"}`), encoded))
+}
+
+func TestVerifyReleaseFileWithoutKey(t *testing.T) {
+	t.Parallel()
+	fetch := func(name string) ([]byte, error) { return nil, errors.New("no signature") }
+	cfg := &core.Configuration{}
+	cfg.Updates.PublicKey = "<optional>"
+	assert.Error(t, verifyReleaseFile(cfg, fetch, manifestName, nil))
+	cfg.Updates.Insecure = true
+	assert.NoError(t, verifyReleaseFile(cfg, fetch, manifestName, nil))
+}
+
+func TestLatestRelease(t *testing.T) {
+	t.Parallel()
+	m := releaseManifest{Channel: "stable", Releases: []release{
+		{Version: "0.62.2", OS: "darwin", Arch: "amd64"},
+		{Version: "0.63.0", OS: "darwin", Arch: "amd64"},
+		{Version: "0.64.0", OS: "linux", Arch: "amd64"},
+		{Version: "0.65.0", OS: "darwin", Arch: "arm64"},
+	}}
+	r, err := m.latest("darwin", "amd64")
+	assert.NoError(t, err)
+	assert.Equal(t, "0.63.0", r.Version)
+	_, err = m.latest("windows", "amd64")
+	assert.Error(t, err)
+}
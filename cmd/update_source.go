@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/benchlabs/bub/core"
+	"github.com/benchlabs/bub/integrations/aws"
+	"github.com/benchlabs/bub/utils"
+)
+
+const checksumsName = "SHA256SUMS"
+
+// releaseSource finds and downloads the bub releases.
+type releaseSource interface {
+	Latest(channel, goos, goarch string) (*release, error)
+	Download(rel *release) ([]byte, error)
+}
+
+func getReleaseSource(cfg *core.Configuration) (releaseSource, error) {
+	switch cfg.Updates.Source {
+	case "", "s3":
+		return &manifestSource{cfg: cfg, fetch: func(name string) ([]byte, error) {
+			return readS3Object(cfg.Updates.Region, cfg.Updates.Bucket, path.Join(cfg.Updates.Prefix, name))
+		}}, nil
+	case "https":
+		if cfg.Updates.URL == "" {
+			return nil, fmt.Errorf("'updates.url' is required for the https source")
+		}
+		return &manifestSource{cfg: cfg, fetch: func(name string) ([]byte, error) {
+			return httpGet(strings.TrimSuffix(cfg.Updates.URL, "/")+"/"+name, nil)
+		}}, nil
+	case "github":
+		if cfg.Updates.Repository == "" {
+			return nil, fmt.Errorf("'updates.repository' is required for the github source, e.g. 'benchlabs/bub'")
+		}
+		return &githubSource{cfg: cfg}, nil
+	}
+	return nil, fmt.Errorf("unknown updates source '%s', use s3, github or https", cfg.Updates.Source)
+}
+
+func httpGet(url string, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func readS3Object(region, bucket, key string) ([]byte, error) {
+	s3cfg := aws.GetAWSConfig(region)
+	sess, err := session.NewSession(&s3cfg)
+	if err != nil {
+		return nil, err
+	}
+	buf := awssdk.NewWriteAtBuffer([]byte{})
+	_, err = s3manager.NewDownloader(sess).Download(buf, &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download s3://%s/%s: %v", bucket, key, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// manifestSource reads the signed manifest of the channel, from S3 or a plain HTTPS index.
+type manifestSource struct {
+	cfg *core.Configuration
+	// fetch reads a file relative to the root of the releases.
+	fetch func(name string) ([]byte, error)
+}
+
+func (s *manifestSource) Latest(channel, goos, goarch string) (*release, error) {
+	name := path.Join(channel, manifestName)
+	data, err := s.fetch(name)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifyReleaseFile(s.cfg, s.fetch, name, data); err != nil {
+		return nil, err
+	}
+	manifest := &releaseManifest{}
+	if err = json.Unmarshal(data, manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Channel != channel {
+		return nil, fmt.Errorf("the manifest is for the '%s' channel, expected '%s'", manifest.Channel, channel)
+	}
+	return manifest.latest(goos, goarch)
+}
+
+func (s *manifestSource) Download(rel *release) ([]byte, error) {
+	return s.fetch(rel.Path)
+}
+
+// verifyReleaseFile checks the '.sig' signature of the file, unless 'updates.insecure' is set.
+func verifyReleaseFile(cfg *core.Configuration, fetch func(string) ([]byte, error), name string, data []byte) error {
+	if !isConfigured(cfg.Updates.PublicKey) {
+		if !cfg.Updates.Insecure {
+			return errors.New("no public key configured to verify the release, set 'updates.publicKey', or 'updates.insecure: true' to skip the verification")
+		}
+		log.Print("The updates are insecure, the release signature is not verified.")
+		return nil
+	}
+	sig, err := fetch(name + ".sig")
+	if err != nil {
+		return err
+	}
+	return verifySignature(cfg.Updates.PublicKey, data, sig)
+}
+
+// githubSource uses the GitHub releases of 'updates.repository'. Pre-releases
+// are the beta channel. Each release has a gzipped binary per platform,
+// e.g. 'bub-darwin-amd64.gz', and a signed 'SHA256SUMS' file.
+type githubSource struct {
+	cfg    *core.Configuration
+	assets map[string]string
+}
+
+type githubRelease struct {
+	TagName    string `json:"tag_name"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+	Assets     []struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	} `json:"assets"`
+}
+
+func (s *githubSource) headers(accept string) map[string]string {
+	headers := map[string]string{"Accept": accept}
+	// the token is only needed for private repositories.
+	if err := core.LookupCredentialItem("GitHub Token", &s.cfg.GitHub.Token); err == nil {
+		headers["Authorization"] = "token " + s.cfg.GitHub.Token
+	}
+	return headers
+}
+
+func (s *githubSource) Latest(channel, goos, goarch string) (*release, error) {
+	data, err := httpGet("https://api.github.com/repos/"+s.cfg.Updates.Repository+"/releases",
+		s.headers("application/vnd.github.v3+json"))
+	if err != nil {
+		return nil, err
+	}
+	var releases []githubRelease
+	if err = json.Unmarshal(data, &releases); err != nil {
+		return nil, err
+	}
+
+	var newest *githubRelease
+	for i, r := range releases {
+		if r.Draft || (r.Prerelease && channel != "beta") {
+			continue
+		}
+		if _, err := utils.ParseVersion(r.TagName); err != nil {
+			continue
+		}
+		if newest == nil || utils.CompareVersions(r.TagName, newest.TagName) > 0 {
+			newest = &releases[i]
+		}
+	}
+	if newest == nil {
+		return nil, fmt.Errorf("no release found in %s on the '%s' channel", s.cfg.Updates.Repository, channel)
+	}
+
+	s.assets = map[string]string{}
+	for _, a := range newest.Assets {
+		s.assets[a.Name] = a.URL
+	}
+	binary := fmt.Sprintf("bub-%s-%s.gz", goos, goarch)
+	if _, ok := s.assets[binary]; !ok {
+		return nil, fmt.Errorf("no %s asset in the release %s", binary, newest.TagName)
+	}
+	checksums, err := s.fetchAsset(checksumsName)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifyReleaseFile(s.cfg, s.fetchAsset, checksumsName, checksums); err != nil {
+		return nil, err
+	}
+	sum, err := findChecksum(checksums, binary)
+	if err != nil {
+		return nil, err
+	}
+	return &release{
+		Version: strings.TrimPrefix(newest.TagName, "v"),
+		OS:      goos,
+		Arch:    goarch,
+		Path:    binary,
+		SHA256:  sum,
+	}, nil
+}
+
+func (s *githubSource) fetchAsset(name string) ([]byte, error) {
+	url, ok := s.assets[name]
+	if !ok {
+		return nil, fmt.Errorf("no %s asset in the release", name)
+	}
+	return httpGet(url, s.headers("application/octet-stream"))
+}
+
+func (s *githubSource) Download(rel *release) ([]byte, error) {
+	return s.fetchAsset(rel.Path)
+}
+
+// findChecksum reads the checksum of the file from the 'shasum -a 256' output.
+func findChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && path.Base(strings.TrimPrefix(fields[1], "*")) == name {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("no checksum found for %s", name)
+}
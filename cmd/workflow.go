@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"github.com/benchlabs/bub/core"
 	"github.com/benchlabs/bub/integrations/atlassian"
+	"github.com/benchlabs/bub/integrations/ci"
 	"github.com/benchlabs/bub/integrations/github"
 	"github.com/benchlabs/bub/utils"
 	"log"
+	"time"
 )
 
 type Workflow struct {
@@ -102,7 +104,13 @@ func (wf *Workflow) MassDone(noOperation bool) error {
 	})
 }
 
-func (wf *Workflow) CreatePR(title, body string, review bool) error {
+// CreatePR waits for the CI first when waitCI is set, a ciTimeout of 0 waits forever.
+func (wf *Workflow) CreatePR(title, body string, review, waitCI bool, ciTimeout time.Duration) error {
+	if waitCI {
+		if err := wf.waitForCI(ciTimeout); err != nil {
+			return err
+		}
+	}
 	if review || utils.AskForConfirmation("Transition issue?") {
 		err := wf.JIRA().TransitionIssue("", "review")
 		if err != nil {
@@ -112,6 +120,30 @@ func (wf *Workflow) CreatePR(title, body string, review bool) error {
 	return wf.GitHub().CreatePR(title, body, "")
 }
 
+// waitForCI pushes the branch and fails unless the build of HEAD succeeds.
+func (wf *Workflow) waitForCI(timeout time.Duration) error {
+	provider, err := getCIProvider(wf.cfg, wf.manifest)
+	if err != nil {
+		return err
+	}
+	if err = wf.Git().Push(wf.cfg); err != nil {
+		return err
+	}
+	head, err := wf.Git().CurrentHEAD()
+	if err != nil {
+		return err
+	}
+	log.Printf("Waiting for %s to build %s...", provider.Name(), head)
+	status, err := provider.Wait(head, timeout)
+	if err != nil {
+		return err
+	}
+	if status.State != ci.StateSuccess {
+		return fmt.Errorf("the build is %s, not creating the PR: %s", status.State, status.URL)
+	}
+	return nil
+}
+
 func (wf *Workflow) Log() error {
 	c, err := wf.Git().PickCommit(wf.Git().Log())
 	if err != nil {
@@ -7,6 +7,7 @@ import (
 	"github.com/benchlabs/bub/utils"
 	"github.com/urfave/cli"
 	"os"
+	"time"
 )
 
 func buildWorkflowCmds(cfg *core.Configuration, manifest *core.Manifest) []cli.Command {
@@ -57,6 +58,8 @@ func buildWorkflowCmds(cfg *core.Configuration, manifest *core.Manifest) []cli.C
 			Flags: []cli.Flag{
 				cli.BoolFlag{Name: compare, Usage: "Open only the compare page (PR creation page)."},
 				cli.BoolFlag{Name: transition, Usage: "Transition the issue to review."},
+				cli.BoolFlag{Name: "wait-ci", Usage: "Push and wait for the CI to pass before creating the PR."},
+				cli.DurationFlag{Name: "ci-timeout", Value: time.Hour, Usage: "Give up waiting for the CI after the duration, 0 to wait forever."},
 			},
 			Action: func(c *cli.Context) error {
 				if c.Bool(compare) {
@@ -73,7 +76,7 @@ func buildWorkflowCmds(cfg *core.Configuration, manifest *core.Manifest) []cli.C
 				if len(c.Args()) > 1 {
 					body = c.Args().Get(1)
 				}
-				return MustInitWorkflow(cfg, manifest).CreatePR(title, body, c.Bool("transition"))
+				return MustInitWorkflow(cfg, manifest).CreatePR(title, body, c.Bool("transition"), c.Bool("wait-ci"), c.Duration("ci-timeout"))
 			},
 		},
 		buildJIRATransitionIssueCmd(cfg),
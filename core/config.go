@@ -12,12 +12,15 @@ import (
 	"os"
 	"os/user"
 	"path"
+	"sort"
 	"strings"
+	"text/tabwriter"
 )
 
 const (
-	ConfigUserFile   = "config.yml"
-	ConfigSharedFile = "shared.yml"
+	ConfigUserFile    = "config.yml"
+	ConfigSharedFile  = "shared.yml"
+	ConfigProjectFile = ".bub.yml"
 )
 
 type RDSConfiguration struct {
@@ -27,6 +30,14 @@ type RDSConfiguration struct {
 type Environment struct {
 	Prefix, Region, Domain string
 	JumpHost               string `yaml:"jumphost"`
+	// Connection is ssh (default) or ssm, to use SSM Session Manager instead of the jump host.
+	Connection string
+	// SSMTarget is the instance forwarding the RDS and Vault ports when using ssm.
+	SSMTarget string `yaml:"ssmTarget"`
+}
+
+func (e *Environment) UsesSSM() bool {
+	return e.Connection == "ssm"
 }
 
 type User struct {
@@ -53,8 +64,11 @@ type Configuration struct {
 		Project, Board             string
 		Transitions                []JIRATransition
 	}
-	Jenkins ServiceConfiguration
-	Splunk  struct {
+	Jenkins struct {
+		ServiceConfiguration `yaml:",inline"`
+		Presets              BuildPresets
+	}
+	Splunk struct {
 		Server string
 	}
 	Confluence ServiceConfiguration
@@ -62,7 +76,20 @@ type Configuration struct {
 		Token string
 	}
 	Updates struct {
+		// s3 (default), github or https
+		Source                 string
 		Region, Bucket, Prefix string
+		// GitHub repository, e.g. benchlabs/bub, for the github source.
+		Repository string
+		// Base URL of the releases index for the https source.
+		URL string `yaml:"url"`
+		// stable or beta
+		Channel   string
+		PublicKey string `yaml:"publicKey"`
+		// Insecure installs the releases without a public key, the signature is then not verified.
+		Insecure bool
+		// Notify once a day when a new version is available.
+		Notify bool
 	}
 	Vault struct {
 		AuthMethod, Server, Username, Password, Path string
@@ -106,6 +133,11 @@ aws:
 			jumphost: jump.example.com
 			region: us-west-2
 			domain: staging.internal.example.com
+		- prefix: private
+			connection: ssm
+			ssmTarget: i-0123456789abcdef0
+			region: us-west-2
+			domain: private.internal.example.com
 		# if there is no prefix the last entry act as a catch all.
 		- jumphost: jump.example.com
 			region: us-east-1
@@ -118,6 +150,10 @@ github:
 
 jenkins:
 	server: "https://jenkins.example..com"
+	# named build parameters, e.g. 'bub j build --preset smoke', the presets of the manifest take precedence.
+	# presets:
+	# 	smoke:
+	# 		SUITE: smoke
 
 vault:
 	server: "https://vault.example..com"
@@ -138,57 +174,110 @@ circle:
 	token: <optional-change-me>
 
 updates:
+	# s3, github (uses 'repository') or https (uses 'url').
+	source: s3
 	region: us-east-1
 	bucket: s3bucket
 	prefix: contrib/bub
+	channel: stable
+	notify: false
+	# PEM public key used to verify the signature of the release manifest.
+	publicKey: <optional>
+	# Set to true to install the releases without verifying their signature.
+	insecure: false
 
 ssh:
 	connectTimeout: 3
 `
 
+var projectConfig = `---
+# Repository overrides, they take precedence over the shared and user config.
+# Only the regions, git, GitHub organization and reviewers, JIRA project, Jenkins presets,
+# users and ssh keys can be set here. Use 'bub config --preview' to see which layer sets each key.
+`
+
+// projectKeys are the keys a repository can set in its .bub.yml, the other keys could send
+// the credentials, the ssh sessions or the updates to hosts chosen by the repository.
+var projectKeys = []string{
+	"aws.regions",
+	"git.noVerify",
+	"github.organization",
+	"github.reviewers",
+	"jira.project",
+	"jira.board",
+	"jira.transitions",
+	"jenkins.presets",
+	"users",
+	"ssh.connectTimeout",
+}
+
 func GetConfigString() string {
 	return strings.Replace(config, "\t", "  ", -1)
 }
 
-func LoadConfiguration() (*Configuration, error) {
-	baseCfg, err := loadConfiguration(ConfigSharedFile)
-	if err != nil && err != utils.FileDoesNotExist {
-		return nil, err
-	}
-	cfg, err := loadConfiguration(ConfigUserFile)
-	if err != nil {
-		return nil, err
+// ConfigLayer is a configuration file merged into the final configuration.
+type ConfigLayer struct {
+	Name, Path string
+	Optional   bool
+	// Restricted layers can only set the projectKeys.
+	Restricted bool
+}
+
+var profile string
+
+// SetProfile selects the named profile, the configuration files are then read
+// from '~/.config/bub/profiles/<name>' and the credentials stored separately.
+func SetProfile(name string) {
+	profile = name
+}
+
+func GetProfile() string {
+	return profile
+}
+
+// GetConfigLayers returns the configuration layers, ordered by precedence.
+func GetConfigLayers() []ConfigLayer {
+	var layers []ConfigLayer
+	if utils.InRepository() {
+		root, err := MustInitGit("").GetRepositoryRootPath()
+		if err == nil {
+			layers = append(layers, ConfigLayer{Name: "project", Path: path.Join(root, ConfigProjectFile), Optional: true, Restricted: true})
+		}
 	}
-	err = mergo.Merge(baseCfg, *cfg)
-	if err != nil {
-		return nil, err
+	return append(layers,
+		ConfigLayer{Name: "shared", Path: GetConfigPath(ConfigSharedFile), Optional: true},
+		ConfigLayer{Name: "user", Path: GetConfigPath(ConfigUserFile)},
+	)
+}
+
+func LoadConfiguration() (*Configuration, error) {
+	cfg := &Configuration{}
+	for _, l := range GetConfigLayers() {
+		layerCfg, err := loadConfiguration(l.Path, l.Restricted)
+		if err == utils.FileDoesNotExist && l.Optional {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// mergo only sets the fields that are still empty, the first layer wins.
+		err = mergo.Merge(cfg, *layerCfg)
+		if err != nil {
+			return nil, err
+		}
 	}
 	if len(cfg.AWS.Regions) == 0 {
 		cfg.AWS.Regions = []string{"us-east-1", "us-west-2"}
 	}
 	resetCredentials := os.Getenv("BUB_UPDATE_CREDENTIALS")
 	if resetCredentials != "" {
-		baseCfg.ResetCredentials = true
+		cfg.ResetCredentials = true
 	}
-	return baseCfg, nil
+	return cfg, nil
 }
 
-func getConfigPath(configFile string) (string, error) {
-	usr, err := user.Current()
-	if err != nil {
-		return "", nil
-	}
-
-	configPath := path.Join(usr.HomeDir, ".config", "bub", configFile)
-	return configPath, nil
-}
-
-func loadConfiguration(configFile string) (*Configuration, error) {
+func loadConfiguration(configPath string, restricted bool) (*Configuration, error) {
 	cfg := &Configuration{}
-	configPath, err := getConfigPath(configFile)
-	if err != nil {
-		return cfg, err
-	}
 	fileExists, _ := utils.PathExists(configPath)
 	if !fileExists {
 		return cfg, utils.FileDoesNotExist
@@ -200,20 +289,94 @@ func loadConfiguration(configFile string) (*Configuration, error) {
 		return cfg, err
 	}
 
+	if restricted {
+		raw := map[interface{}]interface{}{}
+		if err = yaml.Unmarshal(data, &raw); err != nil {
+			return cfg, err
+		}
+		for _, k := range filterProjectConfig("", raw) {
+			log.Printf("Ignoring '%s' in %s, a repository cannot set it.", k, configPath)
+		}
+		if data, err = yaml.Marshal(raw); err != nil {
+			return cfg, err
+		}
+	}
+
 	err = yaml.Unmarshal(data, &cfg)
 	return cfg, err
 }
 
+func isProjectKey(key string) bool {
+	for _, k := range projectKeys {
+		if key == k || strings.HasPrefix(key, k+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func hasProjectKeys(prefix string) bool {
+	for _, k := range projectKeys {
+		if strings.HasPrefix(k, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// filterProjectConfig removes the keys which are not projectKeys and returns them.
+func filterProjectConfig(prefix string, raw map[interface{}]interface{}) []string {
+	var ignored []string
+	for k, v := range raw {
+		key := fmt.Sprintf("%v", k)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if isProjectKey(key) {
+			continue
+		}
+		if nested, ok := v.(map[interface{}]interface{}); ok && hasProjectKeys(key) {
+			ignored = append(ignored, filterProjectConfig(key, nested)...)
+			continue
+		}
+		delete(raw, k)
+		ignored = append(ignored, key)
+	}
+	sort.Strings(ignored)
+	return ignored
+}
+
+// ValidateConfigurationFile checks that a single configuration layer can be parsed.
+func ValidateConfigurationFile(configFile string) error {
+	_, err := loadConfiguration(GetConfigPath(configFile), false)
+	return err
+}
+
 func EditConfiguration(configFile string) error {
 	return utils.CreateAndEdit(GetConfigPath(configFile), GetConfigString())
 }
 
-func GetConfigPath(configFile string) string {
+func EditProjectConfiguration() error {
+	root, err := MustInitGit("").GetRepositoryRootPath()
+	if err != nil {
+		return err
+	}
+	return utils.CreateAndEdit(path.Join(root, ConfigProjectFile), projectConfig)
+}
+
+func GetConfigDir() string {
 	usr, err := user.Current()
 	if err != nil {
 		log.Fatal(err)
 	}
-	return path.Join(usr.HomeDir, ".config", "bub", configFile)
+	if profile != "" {
+		return path.Join(usr.HomeDir, ".config", "bub", "profiles", profile)
+	}
+	return path.Join(usr.HomeDir, ".config", "bub")
+}
+
+func GetConfigPath(configFile string) string {
+	return path.Join(GetConfigDir(), configFile)
 }
 
 func MustSetupConfig() {
@@ -224,15 +387,76 @@ func MustSetupConfig() {
 	}
 }
 
-func ShowConfig(cfg *Configuration) error {
-	yml, _ := yaml.Marshal(cfg)
-	fmt.Println(string(yml))
-	return nil
+// ShowConfig prints the effective value of each key and the layer which set it.
+func ShowConfig() error {
+	values := map[string]string{}
+	origins := map[string]string{}
+	for _, l := range GetConfigLayers() {
+		data, err := ioutil.ReadFile(l.Path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		raw := map[interface{}]interface{}{}
+		if err = yaml.Unmarshal(data, &raw); err != nil {
+			return fmt.Errorf("%s: %v", l.Path, err)
+		}
+		if l.Restricted {
+			filterProjectConfig("", raw)
+		}
+		for k, v := range flattenConfig("", raw) {
+			if _, ok := origins[k]; !ok {
+				values[k] = v
+				origins[k] = l.Name
+			}
+		}
+	}
+	var keys []string
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if profile != "" {
+		fmt.Printf("Profile: %s\n\n", profile)
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "Key\tValue\tLayer")
+	for _, k := range keys {
+		fmt.Fprintln(table, strings.Join([]string{k, values[k], origins[k]}, "\t"))
+	}
+	return table.Flush()
+}
+
+func flattenConfig(prefix string, raw map[interface{}]interface{}) map[string]string {
+	result := map[string]string{}
+	for k, v := range raw {
+		key := fmt.Sprintf("%v", k)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := v.(map[interface{}]interface{}); ok {
+			for nk, nv := range flattenConfig(key, nested) {
+				result[nk] = nv
+			}
+			continue
+		}
+		lowerKey := strings.ToLower(key)
+		if v != nil && (strings.HasSuffix(lowerKey, "password") || strings.HasSuffix(lowerKey, "token")) {
+			result[key] = "********"
+			continue
+		}
+		result[key] = fmt.Sprintf("%v", v)
+	}
+	return result
 }
 
-func CheckServerConfig(server string) {
+func CheckServerConfig(service, server string) {
 	if server == "" {
-		log.Fatal("Server cannot be empty, make sure the config file is properly configured. run 'bub config'.")
+		log.Fatalf("The %s server cannot be empty, make sure the config file is properly configured. "+
+			"Run 'bub config' and 'bub doctor'.", service)
 	}
 }
 
@@ -264,8 +488,42 @@ func LoadCredentialItem(item string, ptr *string, resetCredentials bool) (err er
 	return LoadKeyringItem(item, ptr)
 }
 
+// LookupCredentials is the non-interactive version of LoadCredentials,
+// keyring.ErrNotFound is returned instead of prompting.
+func LookupCredentials(item string, username, password *string) (err error) {
+	if err = LookupCredentialItem(item+" Username", username); err != nil {
+		return err
+	}
+	return LookupCredentialItem(item+" Password", password)
+}
+
+func LookupCredentialItem(item string, ptr *string) error {
+	envVar := os.Getenv(strings.Replace(strings.ToUpper(item), " ", "_", -1))
+	if envVar != "" {
+		*ptr = envVar
+		return nil
+	}
+	if *ptr != "" && !strings.HasPrefix(*ptr, "<optional-") {
+		return nil
+	}
+	pw, err := keyring.Get(keyringService(), item)
+	if err != nil {
+		return err
+	}
+	*ptr = pw
+	return nil
+}
+
+// keyringService keeps the credentials of each profile apart.
+func keyringService() string {
+	if profile != "" {
+		return "bub/" + profile
+	}
+	return "bub"
+}
+
 func LoadKeyringItem(item string, ptr *string) (err error) {
-	service := "bub"
+	service := keyringService()
 	if pw, err := keyring.Get(service, item); err == nil {
 		*ptr = pw
 		return nil
@@ -277,7 +535,7 @@ func LoadKeyringItem(item string, ptr *string) (err error) {
 }
 
 func setKeyringItem(item string, ptr *string) (err error) {
-	service := "bub"
+	service := keyringService()
 	prompt := promptui.Prompt{
 		Label: "Enter " + item,
 	}
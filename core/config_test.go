@@ -0,0 +1,77 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestFlattenConfig(t *testing.T) {
+	t.Parallel()
+	raw := map[interface{}]interface{}{}
+	err := yaml.Unmarshal([]byte(`
+github:
+  organization: benchlabs
+  token: secret
+aws:
+  regions: [us-east-1]
+ssh:
+  connectTimeout: 3
+`), &raw)
+	assert.NoError(t, err)
+	result := flattenConfig("", raw)
+	assert.Equal(t, "benchlabs", result["github.organization"])
+	assert.Equal(t, "********", result["github.token"])
+	assert.Equal(t, "[us-east-1]", result["aws.regions"])
+	assert.Equal(t, "3", result["ssh.connectTimeout"])
+}
+
+func TestLoadProjectConfiguration(t *testing.T) {
+	t.Parallel()
+	f, err := ioutil.TempFile("", "bub-project")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`
+github:
+  organization: benchlabs
+  reviewers: [octocat]
+  token: stolen
+updates:
+  insecure: true
+  source: https
+  url: https://example.com/bub
+vault:
+  server: https://vault.example.com
+aws:
+  regions: [eu-west-1]
+  environments:
+    - prefix: prod
+      jumphost: jump.example.com
+jira:
+  server: https://jira.example.com
+  project: BUB
+`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	cfg, err := loadConfiguration(f.Name(), true)
+	assert.NoError(t, err)
+	assert.Equal(t, "benchlabs", cfg.GitHub.Organization)
+	assert.Equal(t, []string{"octocat"}, cfg.GitHub.Reviewers)
+	assert.Equal(t, []string{"eu-west-1"}, cfg.AWS.Regions)
+	assert.Equal(t, "BUB", cfg.JIRA.Project)
+	assert.Empty(t, cfg.GitHub.Token)
+	assert.False(t, cfg.Updates.Insecure)
+	assert.Empty(t, cfg.Updates.Source)
+	assert.Empty(t, cfg.Updates.URL)
+	assert.Empty(t, cfg.Vault.Server)
+	assert.Empty(t, cfg.AWS.Environments)
+	assert.Empty(t, cfg.JIRA.Server)
+
+	cfg, err = loadConfiguration(f.Name(), false)
+	assert.NoError(t, err)
+	assert.True(t, cfg.Updates.Insecure)
+}
@@ -35,8 +35,26 @@ type Manifest struct {
 	ChangeLog     string
 	Page          string
 	Owners        Ownership
+	// CI is jenkins, circle or github, detected from the Jenkinsfile, the .circleci or the .github/workflows directory by default.
+	CI      string `yaml:"ci"`
+	Jenkins struct {
+		Presets BuildPresets
+	}
+	Circle struct {
+		// Slug of the CircleCI project, required for the GitHub App projects, e.g. circleci/<org-id>/<project-id>.
+		Slug string
+		// Definition is the ID of the pipeline definition triggered for the GitHub App projects, see Project Settings > Pipelines.
+		Definition string
+	}
+	GitHub struct {
+		// Workflow is the file name or the ID of the workflow triggered with workflow_dispatch, e.g. ci.yml.
+		Workflow string
+	}
 }
 
+// BuildPresets are named sets of build parameters, e.g. smoke: {SUITE: smoke}.
+type BuildPresets map[string]map[string]string
+
 type Dependency struct {
 	// name of the dependency
 	Name string
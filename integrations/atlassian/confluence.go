@@ -53,6 +53,29 @@ func MustSetupConfluence(cfg *core.Configuration) {
 	mustLoadConfluenceCredentials(cfg)
 }
 
+// CheckConfluence verifies the stored credentials without prompting.
+func CheckConfluence(cfg *core.Configuration) error {
+	if cfg.Confluence.Server == "" {
+		return errors.New("server not configured")
+	}
+	err := core.LookupCredentials("Confluence", &cfg.Confluence.Username, &cfg.Confluence.Password)
+	if err != nil {
+		return err
+	}
+	api := gopencils.Api(
+		cfg.Confluence.Server+"/rest/api",
+		&gopencils.BasicAuth{Username: cfg.Confluence.Username, Password: cfg.Confluence.Password},
+	)
+	request, err := api.Res("user/current", &map[string]interface{}{}).Get()
+	if err != nil {
+		return err
+	}
+	if request.Raw.StatusCode != 200 {
+		return fmt.Errorf("confluence REST API returns unexpected HTTP status: %s", request.Raw.Status)
+	}
+	return nil
+}
+
 type PageInfo struct {
 	Title string   `json:"title"`
 	Body  PageBody `json:"body"`
@@ -50,8 +50,28 @@ func MustSetupJIRA(cfg *core.Configuration) {
 	mustLoadJIRACredentials(cfg)
 }
 
+// CheckJIRA verifies the stored credentials without prompting.
+func CheckJIRA(cfg *core.Configuration) error {
+	if cfg.JIRA.Server == "" {
+		return errors.New("server not configured")
+	}
+	if err := core.LookupCredentials("JIRA", &cfg.JIRA.Username, &cfg.JIRA.Password); err != nil {
+		return err
+	}
+	j := JIRA{}
+	if err := j.init(cfg); err != nil {
+		return err
+	}
+	req, err := j.client.NewRequest("GET", "rest/api/2/myself", nil)
+	if err != nil {
+		return err
+	}
+	_, err = j.client.Do(req, nil)
+	return err
+}
+
 func (j *JIRA) init(cfg *core.Configuration) error {
-	core.CheckServerConfig(cfg.JIRA.Server)
+	core.CheckServerConfig("JIRA", cfg.JIRA.Server)
 	client, err := jira.NewClient(nil, cfg.JIRA.Server)
 	if err != nil {
 		return err
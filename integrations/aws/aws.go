@@ -2,6 +2,8 @@ package aws
 
 import (
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/benchlabs/bub/utils"
 	"log"
 	"os/user"
@@ -12,6 +14,20 @@ func GetAWSConfig(region string) aws.Config {
 	return aws.Config{Region: aws.String(region)}
 }
 
+// CheckCredentials returns the ARN of the identity used for the region.
+func CheckCredentials(region string) (string, error) {
+	config := GetAWSConfig(region)
+	sess, err := session.NewSession(&config)
+	if err != nil {
+		return "", err
+	}
+	identity, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	return *identity.Arn, nil
+}
+
 func MustSetupConfig() {
 	usr, err := user.Current()
 	if err != nil {
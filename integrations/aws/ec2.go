@@ -2,6 +2,7 @@ package aws
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
@@ -9,8 +10,6 @@ import (
 	"path"
 	"strings"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/benchlabs/bub/core"
 	"github.com/benchlabs/bub/utils"
@@ -19,51 +18,14 @@ import (
 
 type ConnectionParams struct {
 	Configuration *core.Configuration
-	Filter        string
+	Filter        InstanceFilter
 	Output        bool
 	All           bool
 	UseJumpHost   bool
+	UseSSM        bool
 	Args          []string
 }
 
-func FetchInstances(done chan []*ec2.Instance, region string, filter string) {
-	sess, err := session.NewSession()
-	if err != nil {
-		log.Fatalf("Failed to create session %v\n", err)
-	}
-
-	config := GetAWSConfig(region)
-	svc := ec2.New(sess, &config)
-	params := &ec2.DescribeInstancesInput{
-		Filters: []*ec2.Filter{
-			{
-				Name: aws.String("tag:Name"),
-				Values: []*string{
-					aws.String(strings.Join([]string{"*", filter, "*"}, "")),
-				},
-			},
-			{
-				Name: aws.String("instance-state-name"),
-				Values: []*string{
-					aws.String("running"),
-				},
-			},
-		},
-	}
-	resp, err := svc.DescribeInstances(params)
-	if err != nil {
-		log.Fatalf("There was an error listing instances: %v", err.Error())
-	}
-	var instances []*ec2.Instance
-	for _, r := range resp.Reservations {
-		for _, i := range r.Instances {
-			instances = append(instances, i)
-		}
-	}
-	done <- instances
-	return
-}
-
 func getInstanceName(i *ec2.Instance) string {
 	var name string
 	for _, t := range i.Tags {
@@ -77,43 +39,65 @@ func getInstanceName(i *ec2.Instance) string {
 func getUsers(i *ec2.Instance) []string {
 	var users []string
 	for _, t := range i.Tags {
-		if *t.Key == "elasticbeanstalk:environment-name" {
+		if *t.Key == beanstalkEnvironmentTag {
 			users = append(users, "ec2-user")
 		}
 	}
 	return append(users, "ubuntu")
 }
 
-func getJumpHost(name string, cfg *core.Configuration) string {
+func findJumpHost(name string, cfg *core.Configuration) (string, bool) {
 	for _, i := range cfg.AWS.Environments {
 		if strings.HasPrefix(name, i.Prefix) {
-			return i.JumpHost
+			return i.JumpHost, true
 		}
 	}
-	log.Fatal("Could not find jump host in configuration.")
-	return ""
+	return "", false
+}
+
+func getKeyPath(i *ec2.Instance) string {
+	usr, _ := user.Current()
+	return path.Join(usr.HomeDir, ".ssh", *i.KeyName+".pem")
+}
+
+// getJumpHostForInstance returns the jump host when the instance has no public DNS name,
+// or when it is forced.
+func getJumpHostForInstance(i *ec2.Instance, cfg *core.Configuration, useJumpHost bool) (hostname, jumpHost string, err error) {
+	hostname = *i.PublicDnsName
+	if hostname == "" || useJumpHost {
+		name := getInstanceName(i)
+		jumpHost, ok := findJumpHost(name, cfg)
+		if !ok {
+			return "", "", fmt.Errorf("could not find the jump host of %s in the configuration", name)
+		}
+		return *i.PrivateDnsName, jumpHost, nil
+	}
+	return hostname, "", nil
 }
 
 func connect(i *ec2.Instance, params ConnectionParams) error {
 	if !(params.Output || params.All) {
 		log.Println(*i)
 	}
-	usr, _ := user.Current()
-	hostname := *i.PublicDnsName
-	key := path.Join(usr.HomeDir, ".ssh", *i.KeyName+".pem")
+	if useSSM(i, params.Configuration, params.UseSSM) {
+		return connectSSM(i, params)
+	}
+	key := getKeyPath(i)
 	var sshJumpHostArgs []string
 	var scpJumpHostArgs []string
 
-	if hostname == "" || params.UseJumpHost {
-		hostname = *i.PrivateDnsName
-		jumpHost := getJumpHost(getInstanceName(i), params.Configuration)
+	hostname, jumpHost, err := getJumpHostForInstance(i, params.Configuration, params.UseJumpHost)
+	if err != nil {
+		return err
+	}
+	if jumpHost != "" {
 		log.Printf("No public DNS name found, using jump host: %v", jumpHost)
 
 		sshJumpHostArgs = []string{"-A", "-J", jumpHost}
-		scpJumpHostArgs = []string{"-o", fmt.Sprintf("ProxyCommand ssh %v nc %%h %%p", jumpHost)}
+		scpJumpHostArgs = []string{"-o", "ProxyJump=" + jumpHost}
 	}
 
-	go utils.ConfigureITerm(*i.Architecture)
+	go utils.ConfigureITerm(getInstanceName(i))
 	for _, sshUser := range getUsers(i) {
 		host := sshUser + "@" + hostname
 		if isSCP(params) {
@@ -122,7 +106,8 @@ func connect(i *ec2.Instance, params ConnectionParams) error {
 			}
 		}
 
-		if runSSH(i, host, key, sshJumpHostArgs, params) == nil {
+		// only try the next user when ssh itself failed, not the remote command.
+		if err := runSSH(i, host, key, sshJumpHostArgs, params); err == nil || exitCode(err) != sshErrorExitCode {
 			break
 		}
 	}
@@ -134,11 +119,15 @@ func isSCP(params ConnectionParams) bool {
 	return len(params.Args) > 0 && params.Args[0] == "scp"
 }
 
-func runSSH(i *ec2.Instance, host string, key string, args []string, params ConnectionParams) error {
-	connectTimeout := params.Configuration.Ssh.ConnectTimeout
-	if connectTimeout == 0 {
-		connectTimeout = 3
+func getConnectTimeout(cfg *core.Configuration) uint {
+	if cfg.Ssh.ConnectTimeout == 0 {
+		return 3
 	}
+	return cfg.Ssh.ConnectTimeout
+}
+
+func runSSH(i *ec2.Instance, host string, key string, args []string, params ConnectionParams) error {
+	connectTimeout := getConnectTimeout(params.Configuration)
 	args = append(args, "-i", key, host, "-o", fmt.Sprintf("ConnectTimeout=%d", connectTimeout))
 	args = append(args, prepareSSHArgs(params)...)
 
@@ -169,31 +158,24 @@ func runSCP(host string, key string, args []string, params ConnectionParams) err
 	return cmd.Run()
 }
 
+// saveCommandOutput keeps the output even when the command fails, the error is returned afterwards.
 func saveCommandOutput(i *ec2.Instance, cmd *exec.Cmd) error {
-	content, err := cmd.Output()
-	if err != nil {
-		log.Fatal(err)
-	}
+	content, cmdErr := cmd.Output()
 	outputPath := "output-" + getInstanceName(i) + "-" + utils.CurrentTimeForFilename() + ".txt"
-	f, err := os.Create(outputPath)
-	if err != nil {
-		log.Fatal(err)
+	if err := ioutil.WriteFile(outputPath, content, 0644); err != nil {
+		return err
 	}
-	_, err = f.Write(content)
-
-	if err != nil {
-		log.Fatal(err)
-	}
-	f.Close()
 	log.Printf("Saved output to: %v", outputPath)
-
-	return err
+	return cmdErr
 }
 
 func prepareSSHArgs(params ConnectionParams) []string {
-	args := params.Args
+	return prepareRemoteCommand([]string{"-tC"}, params.Args)
+}
+
+// prepareRemoteCommand maps the special commands, like 'jstack', to the scripts on the instances.
+func prepareRemoteCommand(baseArgs []string, args []string) []string {
 	if len(args) > 0 {
-		baseArgs := []string{"-tC"}
 		switch args[0] {
 		case "tmux":
 			arg := ""
@@ -241,24 +223,7 @@ func prepareSCPArgs(args []string, host string) []string {
 }
 
 func ConnectToInstance(params ConnectionParams) error {
-	var instances []*ec2.Instance
-
-	channel := make(chan []*ec2.Instance)
-	regions := params.Configuration.AWS.Regions
-	log.Printf("Fetching instances with tag '%v'", params.Filter)
-
-	for _, region := range regions {
-		go FetchInstances(channel, region, params.Filter)
-	}
-	for i := 0; i < len(regions); i++ {
-		instances = append(instances, <-channel...)
-	}
-	close(channel)
-
-	for i := range instances {
-		name := getInstanceName(instances[i])
-		instances[i].Architecture = &name
-	}
+	instances := getInstances(params.Configuration, params.Filter)
 
 	if len(instances) == 0 {
 		log.Fatal("No instances found.")
@@ -271,6 +236,7 @@ func ConnectToInstance(params ConnectionParams) error {
 				return err
 			}
 		}
+		return nil
 	}
 
 	i, err := pickEC2Instance(instances)
@@ -281,18 +247,23 @@ func ConnectToInstance(params ConnectionParams) error {
 }
 
 func pickEC2Instance(instances []*ec2.Instance) (*ec2.Instance, error) {
-	// The Architecture field is being overwritten
-	// with the instance name tag to make it easier to template.
-	// The alternative was to define a new struct.
+	type ec2Instance struct {
+		*ec2.Instance
+		Name string
+	}
+	var items []ec2Instance
+	for _, i := range instances {
+		items = append(items, ec2Instance{Instance: i, Name: getInstanceName(i)})
+	}
 	templates := &promptui.SelectTemplates{
-		Label: "{{ . }}:",
-		Active: "▶ {{ .InstanceId }}	{{ .Architecture }}",
-		Inactive: "  {{ .InstanceId }}	{{ .Architecture }}",
-		Selected: "▶ {{ .InstanceId }}	{{ .Architecture }}",
+		Label:    "{{ . }}:",
+		Active:   "▶ {{ .InstanceId }}	{{ .Name }}",
+		Inactive: "  {{ .InstanceId }}	{{ .Name }}",
+		Selected: "▶ {{ .InstanceId }}	{{ .Name }}",
 		Details: `
 --------- Instance ----------
 {{ "Id:" | faint }}	{{ .InstanceId }}
-{{ "Name:" | faint }}	{{ .Architecture }}
+{{ "Name:" | faint }}	{{ .Name }}
 {{ "LaunchTime:" | faint }}	{{ .LaunchTime }}
 {{ "PublicDnsName:" | faint }}	{{ .PublicDnsName }}
 {{ "PrivateDnsName:" | faint }}	{{ .PrivateDnsName }}
@@ -304,7 +275,7 @@ func pickEC2Instance(instances []*ec2.Instance) (*ec2.Instance, error) {
 
 	searcher := func(input string, index int) bool {
 		i := instances[index]
-		name := strings.Replace(strings.ToLower(*i.Architecture+*i.InstanceId), " ", "", -1)
+		name := strings.Replace(strings.ToLower(getInstanceName(i)+*i.InstanceId), " ", "", -1)
 		input = strings.Replace(strings.ToLower(input), " ", "", -1)
 
 		return strings.Contains(name, input)
@@ -313,7 +284,7 @@ func pickEC2Instance(instances []*ec2.Instance) (*ec2.Instance, error) {
 	prompt := promptui.Select{
 		Size:              20,
 		Label:             "Select an EC2 Instance",
-		Items:             instances,
+		Items:             items,
 		Templates:         templates,
 		Searcher:          searcher,
 		StartInSearchMode: true,
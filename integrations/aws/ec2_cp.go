@@ -0,0 +1,123 @@
+package aws
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/benchlabs/bub/core"
+)
+
+type CopyParams struct {
+	Configuration *core.Configuration
+	Filter        InstanceFilter
+	UseJumpHost   bool
+	UseSSM        bool
+	Parallel      int
+	Recursive     bool
+	// Download copies Remote from every instance into a subdirectory of Local per instance,
+	// otherwise Local is uploaded to Remote on every instance.
+	Download      bool
+	Remote, Local string
+}
+
+// ParseCopyArgs parses 'FILTER:REMOTE LOCAL' to download, or 'LOCAL FILTER:REMOTE' to upload.
+func ParseCopyArgs(src, dst string) (filter, remote, local string, download bool, err error) {
+	if parts := strings.SplitN(src, ":", 2); len(parts) == 2 && !strings.Contains(dst, ":") {
+		return parts[0], parts[1], dst, true, nil
+	}
+	if parts := strings.SplitN(dst, ":", 2); len(parts) == 2 && !strings.Contains(src, ":") {
+		return parts[0], parts[1], src, false, nil
+	}
+	return "", "", "", false, errors.New("use 'INSTANCE_NAME:REMOTE_PATH LOCAL_PATH' or 'LOCAL_PATH INSTANCE_NAME:REMOTE_PATH'")
+}
+
+// getSCPTarget returns the user@host and the options to reach it through the jump host or SSM.
+func getSCPTarget(i *ec2.Instance, cfg *core.Configuration, useJumpHost, forceSSM bool) (string, []string, error) {
+	user := getUsers(i)[0]
+	if useSSM(i, cfg, forceSSM) {
+		return user + "@" + *i.InstanceId, []string{"-o", "ProxyCommand=" + getSSMProxyCommand(i)}, nil
+	}
+	hostname, jumpHost, err := getJumpHostForInstance(i, cfg, useJumpHost)
+	if err != nil {
+		return "", nil, err
+	}
+	if jumpHost != "" {
+		return user + "@" + hostname, []string{"-o", "ProxyJump=" + jumpHost}, nil
+	}
+	return user + "@" + hostname, nil, nil
+}
+
+// CopyFiles copies the files from or to all the instances matched, with bounded parallelism.
+func CopyFiles(params CopyParams) ([]RunResult, error) {
+	instances := getInstances(params.Configuration, params.Filter)
+	if len(instances) == 0 {
+		return nil, errors.New("no instances found")
+	}
+	if params.Download {
+		log.Printf("Copying '%s' from %d instances to '%s'.", params.Remote, len(instances), params.Local)
+	} else {
+		log.Printf("Copying '%s' to '%s' on %d instances.", params.Local, params.Remote, len(instances))
+	}
+	return forEachInstance(instances, params.Parallel, func(i *ec2.Instance) RunResult {
+		return copyFiles(i, params)
+	}), nil
+}
+
+func copyFiles(i *ec2.Instance, params CopyParams) RunResult {
+	host, args, err := getSCPTarget(i, params.Configuration, params.UseJumpHost, params.UseSSM)
+	result := RunResult{InstanceID: *i.InstanceId, Name: getInstanceName(i), Host: host}
+	if err != nil {
+		result.ExitCode = -1
+		result.Error = err.Error()
+		log.Printf("Failed to copy with %s (%s): %s", result.Name, result.InstanceID, result.Error)
+		return result
+	}
+
+	args = append(args,
+		"-o", "BatchMode=yes",
+		"-o", fmt.Sprintf("ConnectTimeout=%d", getConnectTimeout(params.Configuration)))
+	if i.KeyName != nil {
+		args = append(args, "-i", getKeyPath(i))
+	}
+	if params.Recursive {
+		args = append(args, "-r")
+	}
+	if params.Download {
+		// instances of the same environment share the name, the id keeps the directories apart.
+		dir := path.Join(params.Local, invalidHostChars.ReplaceAllString(result.Name, "-")+"-"+result.InstanceID)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			result.ExitCode = -1
+			result.Error = err.Error()
+			return result
+		}
+		args = append(args, host+":"+params.Remote, dir+"/")
+	} else {
+		args = append(args, params.Local, host+":"+params.Remote)
+	}
+
+	var output bytes.Buffer
+	cmd := exec.Command("scp", args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	start := time.Now()
+	err = cmd.Run()
+	result.Duration = time.Since(start)
+	result.Output = output.String()
+	result.ExitCode = exitCode(err)
+	if err != nil {
+		result.Error = strings.TrimSpace(result.Output)
+		if result.Error == "" {
+			result.Error = err.Error()
+		}
+		log.Printf("Failed to copy with %s (%s): %s", result.Name, result.InstanceID, result.Error)
+	}
+	return result
+}
@@ -0,0 +1,217 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/benchlabs/bub/core"
+)
+
+const beanstalkEnvironmentTag = "elasticbeanstalk:environment-name"
+
+// InstanceFilter is shared by the EC2 commands. Every field is optional, only running instances
+// are matched when no state is given.
+type InstanceFilter struct {
+	// Name is matched anywhere in the Name tag.
+	Name string
+
+	Tags                                   map[string]string
+	States, Types, AMIs, AvailabilityZones []string
+	// OlderThan and NewerThan are compared to the launch time.
+	OlderThan, NewerThan time.Duration
+}
+
+type InstanceSummary struct {
+	InstanceID       string            `json:"instanceId"`
+	Name             string            `json:"name"`
+	State            string            `json:"state"`
+	Type             string            `json:"type"`
+	AMI              string            `json:"ami"`
+	AvailabilityZone string            `json:"availabilityZone"`
+	PrivateIP        string            `json:"privateIp"`
+	PublicDNS        string            `json:"publicDns"`
+	LaunchTime       time.Time         `json:"launchTime"`
+	Uptime           string            `json:"uptime"`
+	Environment      string            `json:"beanstalkEnvironment"`
+	Tags             map[string]string `json:"tags"`
+}
+
+// ParseTags parses 'key=value' expressions, a key alone matches any value.
+func ParseTags(expressions []string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, e := range expressions {
+		parts := strings.SplitN(e, "=", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("invalid tag filter '%s', use key=value", e)
+		}
+		if len(parts) == 1 {
+			tags[parts[0]] = "*"
+		} else {
+			tags[parts[0]] = parts[1]
+		}
+	}
+	return tags, nil
+}
+
+// IsEmpty is true when the filter matches all the running instances.
+func (f InstanceFilter) IsEmpty() bool {
+	return f.Name == "" && len(f.Tags) == 0 && len(f.States) == 0 && len(f.Types) == 0 && len(f.AMIs) == 0 &&
+		len(f.AvailabilityZones) == 0 && f.OlderThan == 0 && f.NewerThan == 0
+}
+
+func (f InstanceFilter) ec2Filters() []*ec2.Filter {
+	var filters []*ec2.Filter
+	add := func(name string, values ...string) {
+		if len(values) > 0 {
+			filters = append(filters, &ec2.Filter{Name: aws.String(name), Values: aws.StringSlice(values)})
+		}
+	}
+	if f.Name != "" {
+		add("tag:Name", "*"+f.Name+"*")
+	}
+	for k, v := range f.Tags {
+		add("tag:"+k, v)
+	}
+	states := f.States
+	if len(states) == 0 {
+		states = []string{ec2.InstanceStateNameRunning}
+	}
+	add("instance-state-name", states...)
+	add("instance-type", f.Types...)
+	add("image-id", f.AMIs...)
+	add("availability-zone", f.AvailabilityZones...)
+	return filters
+}
+
+func (f InstanceFilter) matchesAge(i *ec2.Instance) bool {
+	if i.LaunchTime == nil {
+		return true
+	}
+	age := time.Since(*i.LaunchTime)
+	if f.OlderThan > 0 && age < f.OlderThan {
+		return false
+	}
+	if f.NewerThan > 0 && age > f.NewerThan {
+		return false
+	}
+	return true
+}
+
+func FetchInstances(done chan []*ec2.Instance, region string, filter InstanceFilter) {
+	sess, err := session.NewSession()
+	if err != nil {
+		log.Fatalf("Failed to create session %v\n", err)
+	}
+
+	config := GetAWSConfig(region)
+	svc := ec2.New(sess, &config)
+	params := &ec2.DescribeInstancesInput{Filters: filter.ec2Filters()}
+	var instances []*ec2.Instance
+	err = svc.DescribeInstancesPages(params, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, r := range page.Reservations {
+			for _, i := range r.Instances {
+				if filter.matchesAge(i) {
+					instances = append(instances, i)
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		log.Fatalf("There was an error listing instances: %v", err.Error())
+	}
+	done <- instances
+}
+
+func getInstances(cfg *core.Configuration, filter InstanceFilter) []*ec2.Instance {
+	var instances []*ec2.Instance
+
+	channel := make(chan []*ec2.Instance)
+	regions := cfg.AWS.Regions
+	log.Printf("Fetching instances with tag '%v'", filter.Name)
+
+	for _, region := range regions {
+		go FetchInstances(channel, region, filter)
+	}
+	for i := 0; i < len(regions); i++ {
+		instances = append(instances, <-channel...)
+	}
+	close(channel)
+
+	sort.Slice(instances, func(i, j int) bool {
+		return getInstanceName(instances[i])+*instances[i].InstanceId < getInstanceName(instances[j])+*instances[j].InstanceId
+	})
+	return instances
+}
+
+func formatUptime(d time.Duration) string {
+	days := int(d.Hours()) / 24
+	if days > 0 {
+		return fmt.Sprintf("%dd%dh", days, int(d.Hours())%24)
+	}
+	return fmt.Sprintf("%dh%dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+func summarizeInstance(i *ec2.Instance) InstanceSummary {
+	s := InstanceSummary{
+		InstanceID:       *i.InstanceId,
+		Name:             getInstanceName(i),
+		State:            aws.StringValue(i.State.Name),
+		Type:             aws.StringValue(i.InstanceType),
+		AMI:              aws.StringValue(i.ImageId),
+		AvailabilityZone: aws.StringValue(i.Placement.AvailabilityZone),
+		PrivateIP:        aws.StringValue(i.PrivateIpAddress),
+		PublicDNS:        aws.StringValue(i.PublicDnsName),
+		LaunchTime:       aws.TimeValue(i.LaunchTime),
+		Tags:             map[string]string{},
+	}
+	s.Uptime = formatUptime(time.Since(s.LaunchTime))
+	for _, t := range i.Tags {
+		s.Tags[*t.Key] = *t.Value
+	}
+	s.Environment = s.Tags[beanstalkEnvironmentTag]
+	return s
+}
+
+// ListInstances prints the instances matching the filter as a table or JSON.
+func ListInstances(cfg *core.Configuration, filter InstanceFilter, asJSON bool) error {
+	var summaries []InstanceSummary
+	for _, i := range getInstances(cfg, filter) {
+		summaries = append(summaries, summarizeInstance(i))
+	}
+	if asJSON {
+		data, err := json.MarshalIndent(summaries, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "Instance\tName\tState\tType\tAZ\tPrivate IP\tUptime\tBeanstalk\tTags")
+	for _, s := range summaries {
+		var tags []string
+		for k, v := range s.Tags {
+			if k != "Name" && !strings.HasPrefix(k, "aws:") && k != beanstalkEnvironmentTag {
+				tags = append(tags, k+"="+v)
+			}
+		}
+		sort.Strings(tags)
+		row := []string{
+			s.InstanceID, s.Name, s.State, s.Type, s.AvailabilityZone, s.PrivateIP,
+			s.Uptime, s.Environment, strings.Join(tags, ","),
+		}
+		fmt.Fprintln(table, strings.Join(row, "\t"))
+	}
+	return table.Flush()
+}
@@ -0,0 +1,275 @@
+package aws
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/benchlabs/bub/core"
+	"github.com/benchlabs/bub/utils"
+)
+
+// ssh exits with 255 when the connection fails, otherwise with the exit code of the command.
+const sshErrorExitCode = 255
+
+type RunParams struct {
+	Configuration *core.Configuration
+	Filter        InstanceFilter
+	UseJumpHost   bool
+	UseSSM        bool
+	// Parallel is the maximum number of instances running the command at once.
+	Parallel int
+	// Group prints the output of each instance once it completes, instead of prefixing the lines.
+	Group bool
+	// Report is the path of the optional JSON report.
+	Report string
+	// Confirm asks before running the command, with the number of instances matched.
+	Confirm bool
+	Args    []string
+}
+
+type RunResult struct {
+	InstanceID string        `json:"instanceId"`
+	Name       string        `json:"name"`
+	Host       string        `json:"host"`
+	ExitCode   int           `json:"exitCode"`
+	Error      string        `json:"error,omitempty"`
+	Duration   time.Duration `json:"duration"`
+	Output     string        `json:"output"`
+}
+
+// prefixWriter writes each complete line prefixed with the instance name.
+type prefixWriter struct {
+	prefix string
+	out    io.Writer
+	lock   *sync.Mutex
+	buf    bytes.Buffer
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadBytes('\n')
+		if err != nil {
+			// keep the incomplete line for the next write.
+			w.buf.Write(line)
+			return len(p), nil
+		}
+		w.writeLine(line)
+	}
+}
+
+func (w *prefixWriter) Flush() {
+	if w.buf.Len() > 0 {
+		w.writeLine(append(w.buf.Bytes(), '\n'))
+		w.buf.Reset()
+	}
+}
+
+func (w *prefixWriter) writeLine(line []byte) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	fmt.Fprintf(w.out, "[%s] %s", w.prefix, line)
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
+
+// RunOnInstances executes the command on every instance matching the filter, with bounded parallelism.
+func RunOnInstances(params RunParams) ([]RunResult, error) {
+	if len(params.Args) == 0 {
+		return nil, errors.New("a command is required, e.g. 'bub ec2 run api -- jstack'")
+	}
+	if params.Args[0] == "tmux" || params.Args[0] == "bash" || params.Args[0] == "scp" {
+		return nil, fmt.Errorf("'%s' is interactive and cannot run on a fleet", params.Args[0])
+	}
+	instances := getInstances(params.Configuration, params.Filter)
+	if len(instances) == 0 {
+		return nil, errors.New("no instances found")
+	}
+	command := strings.Join(params.Args, " ")
+	if params.Confirm && !utils.AskForConfirmation(fmt.Sprintf("Run '%s' on %d instances?", command, len(instances))) {
+		return nil, errors.New("aborted")
+	}
+	log.Printf("Running '%s' on %d instances.", command, len(instances))
+
+	lock := &sync.Mutex{}
+	results := forEachInstance(instances, params.Parallel, func(i *ec2.Instance) RunResult {
+		return runOnInstance(i, params, lock)
+	})
+
+	if params.Report != "" {
+		if err := writeRunReport(params.Report, results); err != nil {
+			return results, err
+		}
+		log.Printf("Saved report to: %v", params.Report)
+	}
+	return results, nil
+}
+
+// forEachInstance calls fn on every instance, with at most parallel calls at once.
+func forEachInstance(instances []*ec2.Instance, parallel int, fn func(i *ec2.Instance) RunResult) []RunResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+	results := make([]RunResult, len(instances))
+	semaphore := make(chan bool, parallel)
+	var wg sync.WaitGroup
+	for idx, i := range instances {
+		wg.Add(1)
+		semaphore <- true
+		go func(idx int, i *ec2.Instance) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			results[idx] = fn(i)
+		}(idx, i)
+	}
+	wg.Wait()
+	return results
+}
+
+func runOnInstance(i *ec2.Instance, params RunParams, lock *sync.Mutex) RunResult {
+	if useSSM(i, params.Configuration, params.UseSSM) {
+		return runSSMOnInstance(i, params, lock)
+	}
+	name := getInstanceName(i)
+	result := RunResult{InstanceID: *i.InstanceId, Name: name}
+	hostname, jumpHost, err := getJumpHostForInstance(i, params.Configuration, params.UseJumpHost)
+	if err != nil {
+		result.ExitCode = -1
+		result.Error = err.Error()
+		log.Printf("Failed to run on %s (%s): %s", name, result.InstanceID, result.Error)
+		return result
+	}
+
+	var sshArgs []string
+	if jumpHost != "" {
+		sshArgs = []string{"-J", jumpHost}
+	}
+	if i.KeyName != nil {
+		sshArgs = append(sshArgs, "-i", getKeyPath(i))
+	}
+	start := time.Now()
+	var output bytes.Buffer
+	for _, sshUser := range getUsers(i) {
+		result.Host = sshUser + "@" + hostname
+		output.Reset()
+		args := append(append([]string{}, sshArgs...), result.Host,
+			"-o", fmt.Sprintf("ConnectTimeout=%d", getConnectTimeout(params.Configuration)),
+			"-o", "BatchMode=yes")
+		args = append(args, prepareRemoteCommand([]string{"-C"}, params.Args)...)
+
+		cmd := exec.Command("ssh", args...)
+		var stdout, stderr io.Writer = &output, &output
+		var writers []*prefixWriter
+		if !params.Group {
+			for _, out := range []io.Writer{os.Stdout, os.Stderr} {
+				writers = append(writers, &prefixWriter{prefix: name + " " + *i.InstanceId, out: out, lock: lock})
+			}
+			stdout = io.MultiWriter(&output, writers[0])
+			stderr = io.MultiWriter(&output, writers[1])
+		}
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		err := cmd.Run()
+		for _, w := range writers {
+			w.Flush()
+		}
+		result.ExitCode = exitCode(err)
+		result.Error = ""
+		if err != nil {
+			result.Error = err.Error()
+		}
+		// only try the next user when ssh itself failed, not the remote command.
+		if result.ExitCode != sshErrorExitCode {
+			break
+		}
+	}
+	result.Duration = time.Since(start)
+	result.Output = output.String()
+
+	if params.Group {
+		printGroupedOutput(result, lock)
+	}
+	return result
+}
+
+// runSSMOnInstance sends the command with SSM, the output is only available once it completes.
+func runSSMOnInstance(i *ec2.Instance, params RunParams, lock *sync.Mutex) RunResult {
+	result := RunResult{InstanceID: *i.InstanceId, Name: getInstanceName(i), Host: "ssm:" + *i.InstanceId}
+	start := time.Now()
+	output, code, err := sendSSMCommand(i, params.Args)
+	result.Duration = time.Since(start)
+	result.Output = output
+	result.ExitCode = code
+	if err != nil {
+		result.Error = err.Error()
+		if code == 0 {
+			result.ExitCode = -1
+		}
+	}
+	if params.Group {
+		printGroupedOutput(result, lock)
+	} else {
+		w := &prefixWriter{prefix: result.Name + " " + result.InstanceID, out: os.Stdout, lock: lock}
+		w.Write([]byte(output))
+		w.Flush()
+	}
+	return result
+}
+
+func printGroupedOutput(result RunResult, lock *sync.Mutex) {
+	lock.Lock()
+	defer lock.Unlock()
+	fmt.Printf("===== %s (%s) exit %d =====\n%s\n", result.Name, result.InstanceID, result.ExitCode, result.Output)
+}
+
+func writeRunReport(reportPath string, results []RunResult) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(reportPath, data, 0644)
+}
+
+func PrintRunSummary(results []RunResult) {
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "Instance\tName\tHost\tStatus\tExit\tDuration")
+	succeeded := 0
+	for _, r := range results {
+		status := "OK"
+		if r.ExitCode != 0 {
+			status = "FAIL"
+		} else {
+			succeeded++
+		}
+		row := []string{
+			r.InstanceID, r.Name, r.Host, status, fmt.Sprintf("%d", r.ExitCode),
+			fmt.Sprintf("%.1fs", r.Duration.Seconds()),
+		}
+		fmt.Fprintln(table, strings.Join(row, "\t"))
+	}
+	table.Flush()
+	log.Printf("%d succeeded, %d failed.", succeeded, len(results)-succeeded)
+}
@@ -0,0 +1,36 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/benchlabs/bub/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetJumpHostForInstance(t *testing.T) {
+	t.Parallel()
+	cfg := &core.Configuration{}
+	cfg.AWS.Environments = []core.Environment{{Prefix: "staging", JumpHost: "jump.staging.example.com"}}
+	instance := func(name, publicDNS string) *ec2.Instance {
+		return &ec2.Instance{
+			Tags:           []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String(name)}},
+			PublicDnsName:  aws.String(publicDNS),
+			PrivateDnsName: aws.String("ip-10-0-0-1.ec2.internal"),
+		}
+	}
+
+	hostname, jumpHost, err := getJumpHostForInstance(instance("staging-api", "ec2.example.com"), cfg, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "ec2.example.com", hostname)
+	assert.Empty(t, jumpHost)
+
+	hostname, jumpHost, err = getJumpHostForInstance(instance("staging-api", ""), cfg, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "ip-10-0-0-1.ec2.internal", hostname)
+	assert.Equal(t, "jump.staging.example.com", jumpHost)
+
+	_, _, err = getJumpHostForInstance(instance("production-api", ""), cfg, false)
+	assert.Error(t, err)
+}
@@ -20,6 +20,8 @@ import (
 
 type RDS struct {
 	cfg *core.Configuration
+	// UseSSM forwards the ports with SSM Session Manager, even if the environment uses a jump host.
+	UseSSM bool
 }
 
 type DBInstances []*rds.DBInstance
@@ -45,7 +47,7 @@ func GetRDS(cfg *core.Configuration) *RDS {
 	return &RDS{cfg: cfg}
 }
 
-func (r *RDS) ConnectToRDSInstance(filter string, args []string) error {
+func (r *RDS) fetchRDSInstances(filter string) DBInstances {
 	channel := make(chan []*rds.DBInstance)
 	regions := r.cfg.AWS.Regions
 	for _, region := range regions {
@@ -58,7 +60,7 @@ func (r *RDS) ConnectToRDSInstance(filter string, args []string) error {
 			}
 			var rows []*rds.DBInstance
 			for _, i := range resp.DBInstances {
-				if strings.Contains(*i.Endpoint.Address, filter) {
+				if i.Endpoint != nil && strings.Contains(*i.Endpoint.Address, filter) {
 					rows = append(rows, i)
 				}
 			}
@@ -73,18 +75,25 @@ func (r *RDS) ConnectToRDSInstance(filter string, args []string) error {
 	close(channel)
 
 	sort.Sort(instances)
+	return instances
+}
 
+func (r *RDS) getRDSInstance(filter string) (*rds.DBInstance, error) {
+	instances := r.fetchRDSInstances(filter)
 	if len(instances) == 0 {
-		log.Fatal("No instances found.")
+		return nil, errors.New("no instances found")
 	} else if len(instances) == 1 {
-		return r.connectToRDSInstance(instances[0], args)
+		return instances[0], nil
 	}
+	return r.pickRDSInstance(instances)
+}
 
-	instance, err := r.pickRDSInstance(instances)
+func (r *RDS) ConnectToRDSInstance(filter string, args []string) error {
+	instance, err := r.getRDSInstance(filter)
 	if err != nil {
 		log.Fatalf("Failed to pick instance: %v", err)
 	}
-	return r.connectToRDSInstance(instance, args)
+	return r.connectToRDSInstance(instance, *instance.Endpoint.Address, args)
 }
 
 func (r *RDS) pickRDSInstance(instances []*rds.DBInstance) (*rds.DBInstance, error) {
@@ -161,14 +170,23 @@ func (r *RDS) getEnvironment(endpoint string) core.Environment {
 	return core.Environment{}
 }
 
+// isMySQLEngine is true for the engines of the MySQL family, e.g. mariadb or aurora-mysql.
+func isMySQLEngine(engine string) bool {
+	switch engine {
+	case "mysql", "mariadb", "aurora", "aurora-mysql":
+		return true
+	}
+	return false
+}
+
 func (r *RDS) getEngineConfiguration(engine string) EngineConfiguration {
-	if engine == "mysql" {
+	if isMySQLEngine(engine) {
 		return EngineConfiguration{3306, "mycli", "mysql"}
 	}
 	return EngineConfiguration{5432, "pgcli", "psql"}
 }
 
-func (r *RDS) rdsCleanup(tunnel ssh.Connection) error {
+func (r *RDS) rdsCleanup(tunnel *ssh.Connection) error {
 	utils.ResetITerm()
 	return tunnel.Close()
 }
@@ -196,31 +214,55 @@ func (r *RDS) fetchConfigFromVault(endpoint string, rdsConfig *core.RDSConfigura
 	return nil
 }
 
-func (r *RDS) connectToRDSInstance(instance *rds.DBInstance, args []string) error {
+// openTunnel forwards a local port to the instance and completes the credentials from Vault.
+// The environment and the credentials are looked up with configEndpoint, the endpoint of the
+// source instance for the clones.
+func (r *RDS) openTunnel(instance *rds.DBInstance, configEndpoint string, port int) (*ssh.Connection, int, core.RDSConfiguration, error) {
 	endpoint := *instance.Endpoint.Address
-	rdsConfig := r.getRDSConfig(endpoint)
-	port := ssh.GetPort()
+	rdsConfig := r.getRDSConfig(configEndpoint)
+	if port == 0 {
+		port = ssh.GetPort()
+	} else if ssh.IsListening(port) {
+		return nil, 0, rdsConfig, fmt.Errorf("the port %d is already in use", port)
+	}
 	engine := r.getEngineConfiguration(*instance.Engine)
 
-	environment := r.getEnvironment(endpoint)
-	tunnel := ssh.Connection{
+	environment := r.getEnvironment(configEndpoint)
+	tunnel := &ssh.Connection{
 		JumpHost: environment.JumpHost,
 		Tunnels: map[string]ssh.Tunnel{
 			"rds":   {LocalPort: port, RemoteHost: endpoint, RemotePort: engine.Port},
 			"vault": vault.GetVaultTunnelConfiguration(&environment),
 		},
 	}
+	if r.UseSSM || environment.UsesSSM() {
+		if environment.SSMTarget == "" {
+			return nil, 0, rdsConfig, fmt.Errorf("no ssmTarget configured for the '%s' environment, run 'bub config'", environment.Prefix)
+		}
+		tunnel.SSMTarget, tunnel.Region = environment.SSMTarget, environment.Region
+	}
 
 	err := tunnel.Connect()
 	if err != nil {
-		return err
+		return nil, 0, rdsConfig, err
 	}
 	if rdsConfig.Database == "" {
-		err = r.fetchConfigFromVault(endpoint, &rdsConfig, &tunnel)
+		err = r.fetchConfigFromVault(configEndpoint, &rdsConfig, tunnel)
 		if err != nil {
-			return err
+			tunnel.Close()
+			return nil, 0, rdsConfig, err
 		}
 	}
+	return tunnel, port, rdsConfig, nil
+}
+
+func (r *RDS) connectToRDSInstance(instance *rds.DBInstance, configEndpoint string, args []string) error {
+	endpoint := *instance.Endpoint.Address
+	engine := r.getEngineConfiguration(*instance.Engine)
+	tunnel, port, rdsConfig, err := r.openTunnel(instance, configEndpoint, 0)
+	if err != nil {
+		return err
+	}
 
 	env := []string{
 		fmt.Sprintf("PATH=%s", os.Getenv("PATH")),
@@ -267,7 +309,7 @@ func (r *RDS) connectToRDSInstance(instance *rds.DBInstance, args []string) erro
 	}
 
 	isDefaultCommand := strings.Contains(command, engine.Command) || strings.Contains(command, engine.CommandAlt)
-	if *instance.Engine == "mysql" && isDefaultCommand {
+	if isMySQLEngine(*instance.Engine) && isDefaultCommand {
 		args = append(args, fmt.Sprintf("-u'%s'", rdsConfig.User), rdsConfig.Database)
 	}
 
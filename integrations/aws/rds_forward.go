@@ -0,0 +1,122 @@
+package aws
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"os/user"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/benchlabs/bub/core"
+)
+
+type ForwardParams struct {
+	Filter string
+	// Port is the local port, a random one is used when 0.
+	Port int
+	// PgPass adds the credentials to '~/.pgpass' until the tunnel is closed.
+	PgPass bool
+	// DSN prints the connection string, with the password.
+	DSN bool
+}
+
+func getPgPassPath() string {
+	usr, _ := user.Current()
+	return path.Join(usr.HomeDir, ".pgpass")
+}
+
+func pgPassEscape(value string) string {
+	return strings.Replace(strings.Replace(value, `\`, `\\`, -1), ":", `\:`, -1)
+}
+
+func getPgPassEntry(port int, cfg core.RDSConfiguration) string {
+	return strings.Join([]string{
+		"127.0.0.1", fmt.Sprintf("%d", port),
+		pgPassEscape(cfg.Database), pgPassEscape(cfg.User), pgPassEscape(cfg.Password),
+	}, ":")
+}
+
+// updatePgPass adds or removes the entry, psql ignores the file unless it is only readable by the user.
+func updatePgPass(entry string, add bool) error {
+	pgPassPath := getPgPassPath()
+	content, err := ioutil.ReadFile(pgPassPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(string(content), "\n"), "\n") {
+		if line != "" && line != entry {
+			lines = append(lines, line)
+		}
+	}
+	if add {
+		lines = append(lines, entry)
+	}
+	return ioutil.WriteFile(pgPassPath, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
+
+func getDSN(engine string, port int, cfg core.RDSConfiguration) string {
+	if isMySQLEngine(engine) {
+		_, dsn := getDriver(engine, port, cfg)
+		return dsn
+	}
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.User, cfg.Password),
+		Host:     fmt.Sprintf("127.0.0.1:%d", port),
+		Path:     cfg.Database,
+		RawQuery: "sslmode=require",
+	}
+	return dsn.String()
+}
+
+// ForwardRDSInstance keeps the tunnel to the instance open for GUI clients and local services, until interrupted.
+func (r *RDS) ForwardRDSInstance(params ForwardParams) error {
+	instance, err := r.getRDSInstance(params.Filter)
+	if err != nil {
+		return err
+	}
+	tunnel, port, rdsConfig, err := r.openTunnel(instance, *instance.Endpoint.Address, params.Port)
+	if err != nil {
+		return err
+	}
+	defer tunnel.Close()
+
+	if params.PgPass {
+		if isMySQLEngine(*instance.Engine) {
+			log.Print("The .pgpass file is only used by the PostgreSQL clients, skipping.")
+			params.PgPass = false
+		} else {
+			entry := getPgPassEntry(port, rdsConfig)
+			if err = updatePgPass(entry, true); err != nil {
+				return err
+			}
+			defer func() {
+				if err := updatePgPass(entry, false); err != nil {
+					log.Printf("Failed to remove the entry from %s: %v", getPgPassPath(), err)
+				}
+			}()
+		}
+	}
+
+	log.Printf("Forwarding %s", *instance.Endpoint.Address)
+	fmt.Printf("Host:     127.0.0.1\nPort:     %d\nDatabase: %s\nUser:     %s\n", port, rdsConfig.Database, rdsConfig.User)
+	if params.PgPass {
+		fmt.Printf("Password: in %s\n", getPgPassPath())
+	}
+	if params.DSN {
+		fmt.Printf("DSN:      %s\n", getDSN(*instance.Engine, port, rdsConfig))
+	}
+	log.Print("Press Ctrl-C to close the tunnel.")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	log.Print("Closing the tunnel...")
+	return nil
+}
@@ -0,0 +1,247 @@
+package aws
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/benchlabs/bub/core"
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+type QueryParams struct {
+	Filter string
+	SQL    string
+	// Format is table, csv or json.
+	Format string
+	// ReadWrite allows the query to modify data, it runs in a read-only transaction by default.
+	ReadWrite bool
+	Timeout   time.Duration
+}
+
+type queryResult struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// pqQuote quotes a value of a lib/pq connection string.
+func pqQuote(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	return "'" + strings.Replace(value, "'", `\'`, -1) + "'"
+}
+
+func getDriver(engine string, port int, cfg core.RDSConfiguration) (driver, dsn string) {
+	if isMySQLEngine(engine) {
+		mysqlCfg := mysql.NewConfig()
+		mysqlCfg.User = cfg.User
+		mysqlCfg.Passwd = cfg.Password
+		mysqlCfg.Net = "tcp"
+		mysqlCfg.Addr = fmt.Sprintf("127.0.0.1:%d", port)
+		mysqlCfg.DBName = cfg.Database
+		// the certificate does not match 127.0.0.1, the traffic is still encrypted.
+		mysqlCfg.TLSConfig = "skip-verify"
+		return "mysql", mysqlCfg.FormatDSN()
+	}
+	return "postgres", fmt.Sprintf("host=127.0.0.1 port=%d user=%s password=%s dbname=%s sslmode=require",
+		port, pqQuote(cfg.User), pqQuote(cfg.Password), pqQuote(cfg.Database))
+}
+
+// getTimeoutStatement limits the duration of the statements of the session, MySQL only limits the SELECT ones.
+// It is empty for MySQL 5.6, aurora included, and MariaDB 10.0 which have no such variable, the
+// deadline of the context then only stops the client.
+func getTimeoutStatement(engine, version string, timeout time.Duration) string {
+	millis := timeout.Nanoseconds() / int64(time.Millisecond)
+	switch {
+	case engine == "mariadb" && strings.HasPrefix(version, "10.0."):
+		return ""
+	case engine == "mariadb":
+		return fmt.Sprintf("SET SESSION max_statement_time = %.3f", timeout.Seconds())
+	case engine == "aurora" || strings.HasPrefix(version, "5.5.") || strings.HasPrefix(version, "5.6."):
+		return ""
+	case isMySQLEngine(engine):
+		return fmt.Sprintf("SET SESSION max_execution_time = %d", millis)
+	default:
+		return fmt.Sprintf("SET LOCAL statement_timeout = %d", millis)
+	}
+}
+
+// QueryRDSInstances runs the query on every instance matched and prints the merged results.
+// An 'instance' column is added when more than one instance is matched.
+func (r *RDS) QueryRDSInstances(params QueryParams) error {
+	switch params.Format {
+	case "table", "csv", "json":
+	default:
+		return fmt.Errorf("unknown format '%s', use table, csv or json", params.Format)
+	}
+	instances := r.fetchRDSInstances(params.Filter)
+	if len(instances) == 0 {
+		return errors.New("no instances found")
+	}
+
+	merged := &queryResult{}
+	for _, instance := range instances {
+		name := strings.Split(*instance.Endpoint.Address, ".")[0]
+		log.Printf("Querying %s...", name)
+		result, err := r.queryRDSInstance(instance, params)
+		if err != nil {
+			return errors.Wrapf(err, "failed to query %s", name)
+		}
+		if len(instances) == 1 {
+			merged = result
+			break
+		}
+		columns := append([]string{"instance"}, result.Columns...)
+		if merged.Columns == nil {
+			merged.Columns = columns
+		} else if strings.Join(merged.Columns, ",") != strings.Join(columns, ",") {
+			return fmt.Errorf("the columns of %s differ from the previous instances: %s", name, strings.Join(result.Columns, ", "))
+		}
+		for _, row := range result.Rows {
+			merged.Rows = append(merged.Rows, append([]interface{}{name}, row...))
+		}
+	}
+	log.Printf("%d rows.", len(merged.Rows))
+	return printQueryResult(os.Stdout, merged, params.Format)
+}
+
+func (r *RDS) queryRDSInstance(instance *rds.DBInstance, params QueryParams) (*queryResult, error) {
+	tunnel, port, rdsConfig, err := r.openTunnel(instance, *instance.Endpoint.Address, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer tunnel.Close()
+
+	driver, dsn := getDriver(*instance.Engine, port, rdsConfig)
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if params.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, params.Timeout)
+		defer cancel()
+	}
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: !params.ReadWrite})
+	if err != nil {
+		return nil, err
+	}
+	// nothing is committed unless the query is read-write.
+	defer tx.Rollback()
+
+	timeoutStatement := getTimeoutStatement(*instance.Engine, aws.StringValue(instance.EngineVersion), params.Timeout)
+	if params.Timeout > 0 && timeoutStatement != "" {
+		// also stops the query on the server when the client is gone.
+		if _, err = tx.ExecContext(ctx, timeoutStatement); err != nil {
+			return nil, err
+		}
+	}
+	rows, err := tx.QueryContext(ctx, params.SQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &queryResult{}
+	if result.Columns, err = rows.Columns(); err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		values := make([]interface{}, len(result.Columns))
+		pointers := make([]interface{}, len(values))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err = rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			// text columns are returned as bytes.
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if params.ReadWrite {
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func formatValue(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return value.Format(time.RFC3339)
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+func printQueryResult(w io.Writer, result *queryResult, format string) error {
+	switch format {
+	case "json":
+		var objects []map[string]interface{}
+		for _, row := range result.Rows {
+			object := map[string]interface{}{}
+			for i, c := range result.Columns {
+				object[c] = row[i]
+			}
+			objects = append(objects, object)
+		}
+		data, err := json.MarshalIndent(objects, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case "csv":
+		writer := csv.NewWriter(w)
+		if err := writer.Write(result.Columns); err != nil {
+			return err
+		}
+		for _, row := range result.Rows {
+			var record []string
+			for _, v := range row {
+				record = append(record, formatValue(v))
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, strings.Join(result.Columns, "\t"))
+		for _, row := range result.Rows {
+			var record []string
+			for _, v := range row {
+				record = append(record, formatValue(v))
+			}
+			fmt.Fprintln(table, strings.Join(record, "\t"))
+		}
+		return table.Flush()
+	}
+}
@@ -0,0 +1,26 @@
+package aws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTimeoutStatement(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		engine, version, statement string
+	}{
+		{"postgres", "13.7", "SET LOCAL statement_timeout = 1500"},
+		{"mysql", "8.0.28", "SET SESSION max_execution_time = 1500"},
+		{"aurora-mysql", "5.7.mysql_aurora.2.10.2", "SET SESSION max_execution_time = 1500"},
+		{"mysql", "5.6.51", ""},
+		{"aurora", "5.6.10a", ""},
+		{"mariadb", "10.5.16", "SET SESSION max_statement_time = 1.500"},
+		{"mariadb", "10.0.35", ""},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.statement, getTimeoutStatement(test.engine, test.version, 1500*time.Millisecond), test.engine+" "+test.version)
+	}
+}
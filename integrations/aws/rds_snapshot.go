@@ -0,0 +1,246 @@
+package aws
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/benchlabs/bub/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	scratchTag        = "bub:scratch"
+	scratchOwnerTag   = "bub:owner"
+	scratchSourceTag  = "bub:source"
+	scratchExpiresTag = "bub:expires"
+)
+
+type CloneParams struct {
+	Filter string
+	// Snapshot is the identifier of the snapshot to restore, Latest uses the most recent one.
+	Snapshot string
+	Latest   bool
+	Name     string
+	Class    string
+	TTL      time.Duration
+	Args     []string
+}
+
+func getRDSRegion(arn string) string {
+	// arn:aws:rds:<region>:<account>:db:<name>
+	return strings.Split(arn, ":")[3]
+}
+
+func getRDSService(region string) *rds.RDS {
+	config := GetAWSConfig(region)
+	return rds.New(session.New(&config))
+}
+
+func GetScratchName() string {
+	usr, _ := user.Current()
+	return "scratch-" + invalidHostChars.ReplaceAllString(strings.ToLower(usr.Username), "-")
+}
+
+func (r *RDS) fetchSnapshots(instance *rds.DBInstance) ([]*rds.DBSnapshot, error) {
+	svc := getRDSService(getRDSRegion(*instance.DBInstanceArn))
+	var snapshots []*rds.DBSnapshot
+	err := svc.DescribeDBSnapshotsPages(&rds.DescribeDBSnapshotsInput{
+		DBInstanceIdentifier: instance.DBInstanceIdentifier,
+	}, func(page *rds.DescribeDBSnapshotsOutput, lastPage bool) bool {
+		snapshots = append(snapshots, page.DBSnapshots...)
+		return true
+	})
+	sort.Slice(snapshots, func(i, j int) bool {
+		return aws.TimeValue(snapshots[i].SnapshotCreateTime).After(aws.TimeValue(snapshots[j].SnapshotCreateTime))
+	})
+	return snapshots, err
+}
+
+// ListSnapshots prints the automated and manual snapshots of the instances matched, the most recent first.
+func (r *RDS) ListSnapshots(filter string) error {
+	instances := r.fetchRDSInstances(filter)
+	if len(instances) == 0 {
+		return errors.New("no instances found")
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "Snapshot\tInstance\tType\tStatus\tCreated\tEngine\tSize")
+	for _, instance := range instances {
+		snapshots, err := r.fetchSnapshots(instance)
+		if err != nil {
+			return err
+		}
+		for _, s := range snapshots {
+			created := ""
+			if s.SnapshotCreateTime != nil {
+				created = s.SnapshotCreateTime.Local().Format("2006-01-02 15:04")
+			}
+			row := []string{
+				*s.DBSnapshotIdentifier, *s.DBInstanceIdentifier, aws.StringValue(s.SnapshotType),
+				aws.StringValue(s.Status), created,
+				aws.StringValue(s.Engine) + " " + aws.StringValue(s.EngineVersion),
+				fmt.Sprintf("%dGB", aws.Int64Value(s.AllocatedStorage)),
+			}
+			fmt.Fprintln(table, strings.Join(row, "\t"))
+		}
+	}
+	return table.Flush()
+}
+
+func (r *RDS) getSnapshot(instance *rds.DBInstance, params CloneParams) (string, error) {
+	if params.Snapshot != "" {
+		return params.Snapshot, nil
+	}
+	if !params.Latest {
+		return "", errors.New("either --from-snapshot or --latest is required, see 'bub rds snapshots'")
+	}
+	snapshots, err := r.fetchSnapshots(instance)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range snapshots {
+		if aws.StringValue(s.Status) == "available" {
+			return *s.DBSnapshotIdentifier, nil
+		}
+	}
+	return "", fmt.Errorf("no available snapshot found for %s", *instance.DBInstanceIdentifier)
+}
+
+// waitUntilAvailable allows up to 2 hours, restoring large snapshots is slow.
+func waitUntilAvailable(svc *rds.RDS, name string) error {
+	log.Printf("Waiting for %s to be available...", name)
+	return svc.WaitUntilDBInstanceAvailableWithContext(aws.BackgroundContext(),
+		&rds.DescribeDBInstancesInput{DBInstanceIdentifier: aws.String(name)},
+		request.WithWaiterMaxAttempts(240),
+		request.WithWaiterDelay(request.ConstantWaiterDelay(30*time.Second)))
+}
+
+// CloneRDSInstance restores a snapshot of the instance matched into a scratch instance tagged with
+// an expiry, then connects to it with the credentials of the source instance.
+func (r *RDS) CloneRDSInstance(params CloneParams) error {
+	source, err := r.getRDSInstance(params.Filter)
+	if err != nil {
+		return err
+	}
+	snapshot, err := r.getSnapshot(source, params)
+	if err != nil {
+		return err
+	}
+	class := params.Class
+	if class == "" {
+		class = *source.DBInstanceClass
+	}
+	usr, _ := user.Current()
+	expires := time.Now().Add(params.TTL).UTC()
+	svc := getRDSService(getRDSRegion(*source.DBInstanceArn))
+
+	log.Printf("Restoring %s into %s (%s), it expires on %s.", snapshot, params.Name, class, expires.Local().Format(time.RFC1123))
+	input := &rds.RestoreDBInstanceFromDBSnapshotInput{
+		DBInstanceIdentifier: aws.String(params.Name),
+		DBSnapshotIdentifier: aws.String(snapshot),
+		DBInstanceClass:      aws.String(class),
+		MultiAZ:              aws.Bool(false),
+		PubliclyAccessible:   aws.Bool(false),
+		Tags: []*rds.Tag{
+			{Key: aws.String(scratchTag), Value: aws.String("true")},
+			{Key: aws.String(scratchOwnerTag), Value: aws.String(usr.Username)},
+			{Key: aws.String(scratchSourceTag), Value: source.DBInstanceIdentifier},
+			{Key: aws.String(scratchExpiresTag), Value: aws.String(expires.Format(time.RFC3339))},
+		},
+	}
+	if source.DBSubnetGroup != nil {
+		input.DBSubnetGroupName = source.DBSubnetGroup.DBSubnetGroupName
+	}
+	if _, err = svc.RestoreDBInstanceFromDBSnapshot(input); err != nil {
+		return err
+	}
+	if err = waitUntilAvailable(svc, params.Name); err != nil {
+		return err
+	}
+
+	// the restored instance gets the default security group, use the ones of the source.
+	var securityGroups []*string
+	for _, g := range source.VpcSecurityGroups {
+		securityGroups = append(securityGroups, g.VpcSecurityGroupId)
+	}
+	if len(securityGroups) > 0 {
+		_, err = svc.ModifyDBInstance(&rds.ModifyDBInstanceInput{
+			DBInstanceIdentifier: aws.String(params.Name),
+			VpcSecurityGroupIds:  securityGroups,
+			ApplyImmediately:     aws.Bool(true),
+		})
+		if err != nil {
+			return err
+		}
+		// the modification is not immediately visible in the status.
+		time.Sleep(10 * time.Second)
+		if err = waitUntilAvailable(svc, params.Name); err != nil {
+			return err
+		}
+	}
+
+	resp, err := svc.DescribeDBInstances(&rds.DescribeDBInstancesInput{DBInstanceIdentifier: aws.String(params.Name)})
+	if err != nil {
+		return err
+	}
+	clone := resp.DBInstances[0]
+	log.Printf("%s is available at %s. Run 'bub rds cleanup' once done.", params.Name, *clone.Endpoint.Address)
+	return r.connectToRDSInstance(clone, *source.Endpoint.Address, params.Args)
+}
+
+// CleanupScratchInstances deletes the scratch instances past their expiry, without final snapshots.
+func (r *RDS) CleanupScratchInstances(noop bool) error {
+	var expired []*rds.DBInstance
+	for _, instance := range r.fetchRDSInstances("") {
+		svc := getRDSService(getRDSRegion(*instance.DBInstanceArn))
+		resp, err := svc.ListTagsForResource(&rds.ListTagsForResourceInput{ResourceName: instance.DBInstanceArn})
+		if err != nil {
+			return err
+		}
+		tags := map[string]string{}
+		for _, t := range resp.TagList {
+			tags[*t.Key] = *t.Value
+		}
+		if tags[scratchTag] != "true" {
+			continue
+		}
+		expires, err := time.Parse(time.RFC3339, tags[scratchExpiresTag])
+		if err != nil {
+			log.Printf("Skipping %s, invalid expiry '%s'.", *instance.DBInstanceIdentifier, tags[scratchExpiresTag])
+			continue
+		}
+		if time.Now().After(expires) {
+			log.Printf("%s of %s, cloned from %s, expired on %s.", *instance.DBInstanceIdentifier,
+				tags[scratchOwnerTag], tags[scratchSourceTag], expires.Local().Format(time.RFC1123))
+			expired = append(expired, instance)
+		}
+	}
+	if len(expired) == 0 {
+		log.Print("No expired scratch instances.")
+		return nil
+	}
+	if noop || !utils.AskForConfirmation(fmt.Sprintf("Delete the %d expired scratch instances?", len(expired))) {
+		return nil
+	}
+	for _, instance := range expired {
+		svc := getRDSService(getRDSRegion(*instance.DBInstanceArn))
+		_, err := svc.DeleteDBInstance(&rds.DeleteDBInstanceInput{
+			DBInstanceIdentifier: instance.DBInstanceIdentifier,
+			SkipFinalSnapshot:    aws.Bool(true),
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("Deleting %s.", *instance.DBInstanceIdentifier)
+	}
+	return nil
+}
@@ -17,42 +17,65 @@ type RecordSet struct {
 	RecordSet *route53.ResourceRecordSet
 }
 
-func SearchRecords(filter string) (records []RecordSet, err error) {
+func getRoute53Service() (*route53.Route53, error) {
 	sess, err := session.NewSession()
 	if err != nil {
 		return nil, err
 	}
-	svc := route53.New(sess, aws.NewConfig().WithRegion("us-west-2"))
-	zones, err := svc.ListHostedZones(&route53.ListHostedZonesInput{})
+	return route53.New(sess, aws.NewConfig().WithRegion("us-west-2")), nil
+}
+
+func listHostedZones(svc *route53.Route53) ([]*route53.HostedZone, error) {
+	var zones []*route53.HostedZone
+	err := svc.ListHostedZonesPages(&route53.ListHostedZonesInput{}, func(page *route53.ListHostedZonesOutput, lastPage bool) bool {
+		zones = append(zones, page.HostedZones...)
+		return true
+	})
+	return zones, err
+}
+
+func listRecordSets(svc *route53.Route53, zone *route53.HostedZone) ([]*route53.ResourceRecordSet, error) {
+	var recordSets []*route53.ResourceRecordSet
+	err := svc.ListResourceRecordSetsPages(&route53.ListResourceRecordSetsInput{HostedZoneId: zone.Id},
+		func(page *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+			recordSets = append(recordSets, page.ResourceRecordSets...)
+			return true
+		})
+	return recordSets, err
+}
+
+func SearchRecords(filter string) (records []RecordSet, err error) {
+	svc, err := getRoute53Service()
+	if err != nil {
+		return nil, err
+	}
+	zones, err := listHostedZones(svc)
 	if err != nil {
 		return nil, err
 	}
 	var lock sync.Mutex
 
 	wg := sync.WaitGroup{}
-	for _, z := range zones.HostedZones {
+	for _, z := range zones {
 		wg.Add(1)
-		go func(z *route53.HostedZone) error {
-			max := "500"
-			recordSets, err := svc.ListResourceRecordSets(&route53.ListResourceRecordSetsInput{HostedZoneId: z.Id, MaxItems: &max})
-			if err != nil {
-				return err
+		go func(z *route53.HostedZone) {
+			defer wg.Done()
+			recordSets, zoneErr := listRecordSets(svc, z)
+			lock.Lock()
+			defer lock.Unlock()
+			if zoneErr != nil {
+				err = zoneErr
+				return
 			}
-			var r []RecordSet
-			for _, recordSet := range recordSets.ResourceRecordSets {
+			for _, recordSet := range recordSets {
 				if strings.Contains(*recordSet.Name, filter) {
-					r = append(r, RecordSet{Zone: z, RecordSet: recordSet})
+					records = append(records, RecordSet{Zone: z, RecordSet: recordSet})
 				}
 			}
-			lock.Lock()
-			records = append(records, r...)
-			lock.Unlock()
-			wg.Done()
-			return nil
 		}(z)
 	}
 	wg.Wait()
-	return records, nil
+	return records, err
 }
 
 func ListAllRecords(filter string) error {
@@ -0,0 +1,294 @@
+package aws
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/benchlabs/bub/utils"
+)
+
+type RecordChange struct {
+	Name, Type string
+	Values     []string
+	TTL        int64
+	// Zone is the id or the name of the hosted zone, inferred from the record name when empty.
+	Zone string
+}
+
+func normalizeRecordName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, ".")) + "."
+}
+
+func getZoneID(zone *route53.HostedZone) string {
+	return strings.TrimPrefix(*zone.Id, "/hostedzone/")
+}
+
+func isPrivateZone(zone *route53.HostedZone) bool {
+	return zone.Config != nil && aws.BoolValue(zone.Config.PrivateZone)
+}
+
+// findZone picks the most specific zone of the record, the zones with the same name need to
+// be chosen with the zone id.
+func findZone(svc *route53.Route53, recordName, zoneFilter string) (*route53.HostedZone, error) {
+	zones, err := listHostedZones(svc)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []*route53.HostedZone
+	for _, z := range zones {
+		if zoneFilter != "" {
+			if getZoneID(z) == strings.TrimPrefix(zoneFilter, "/hostedzone/") {
+				return z, nil
+			}
+			if normalizeRecordName(zoneFilter) != *z.Name {
+				continue
+			}
+		}
+		if recordName == *z.Name || strings.HasSuffix(recordName, "."+*z.Name) {
+			candidates = append(candidates, z)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no hosted zone found for %s", recordName)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return len(*candidates[i].Name) > len(*candidates[j].Name) })
+	if len(candidates) > 1 && *candidates[0].Name == *candidates[1].Name {
+		var ids []string
+		for _, z := range candidates {
+			if *z.Name == *candidates[0].Name {
+				ids = append(ids, fmt.Sprintf("%s (private: %v)", getZoneID(z), isPrivateZone(z)))
+			}
+		}
+		return nil, fmt.Errorf("several zones are named %s, pick one with --zone: %s", *candidates[0].Name, strings.Join(ids, ", "))
+	}
+	return candidates[0], nil
+}
+
+func getRecordSet(svc *route53.Route53, zone *route53.HostedZone, name, recordType string) (*route53.ResourceRecordSet, error) {
+	resp, err := svc.ListResourceRecordSets(&route53.ListResourceRecordSetsInput{
+		HostedZoneId:    zone.Id,
+		StartRecordName: aws.String(name),
+		StartRecordType: aws.String(recordType),
+		MaxItems:        aws.String("1"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range resp.ResourceRecordSets {
+		if normalizeRecordName(*r.Name) == name && *r.Type == recordType {
+			return r, nil
+		}
+	}
+	return nil, nil
+}
+
+func formatRecordSet(r *route53.ResourceRecordSet) []string {
+	if r.AliasTarget != nil {
+		return []string{fmt.Sprintf("%s\tALIAS %s\t%s", *r.Name, *r.Type, aws.StringValue(r.AliasTarget.DNSName))}
+	}
+	var lines []string
+	for _, v := range r.ResourceRecords {
+		lines = append(lines, fmt.Sprintf("%s\t%d\t%s\t%s", *r.Name, aws.Int64Value(r.TTL), *r.Type, *v.Value))
+	}
+	return lines
+}
+
+// printChanges shows the record sets replaced by the upserts as deletions.
+func printChanges(zone *route53.HostedZone, changes []*route53.Change, replaced []*route53.ResourceRecordSet) {
+	log.Printf("Changes to %s (%s, private: %v):", *zone.Name, getZoneID(zone), isPrivateZone(zone))
+	for _, r := range replaced {
+		for _, line := range formatRecordSet(r) {
+			fmt.Println("- " + line)
+		}
+	}
+	for _, c := range changes {
+		prefix := "+ "
+		if *c.Action == route53.ChangeActionDelete {
+			prefix = "- "
+		}
+		for _, line := range formatRecordSet(c.ResourceRecordSet) {
+			fmt.Println(prefix + line)
+		}
+	}
+}
+
+// Route53 limits a change batch to 1000 record values and 32000 characters of values, an UPSERT counts twice.
+const (
+	maxBatchRecords    = 1000
+	maxBatchCharacters = 32000
+)
+
+// getChangeWeight returns the number of record values and characters the change counts for in a batch.
+func getChangeWeight(c *route53.Change) (records, characters int) {
+	records = len(c.ResourceRecordSet.ResourceRecords)
+	if c.ResourceRecordSet.AliasTarget != nil {
+		records = 1
+	}
+	for _, r := range c.ResourceRecordSet.ResourceRecords {
+		characters += len(aws.StringValue(r.Value))
+	}
+	if *c.Action == route53.ChangeActionUpsert {
+		return 2 * records, 2 * characters
+	}
+	return records, characters
+}
+
+// splitChanges keeps the changes of a record name in the same batch, a deletion is then applied
+// with the upsert replacing it. The deletions stay before the upserts in each batch.
+func splitChanges(changes []*route53.Change, maxRecords, maxCharacters int) ([][]*route53.Change, error) {
+	var names []string
+	groups := map[string][]*route53.Change{}
+	for _, c := range changes {
+		name := normalizeRecordName(*c.ResourceRecordSet.Name)
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], c)
+	}
+
+	var batches [][]*route53.Change
+	var batch []*route53.Change
+	records, characters := 0, 0
+	for _, name := range names {
+		groupRecords, groupCharacters := 0, 0
+		for _, c := range groups[name] {
+			r, chars := getChangeWeight(c)
+			groupRecords += r
+			groupCharacters += chars
+		}
+		if groupRecords > maxRecords || groupCharacters > maxCharacters {
+			return nil, fmt.Errorf("the changes of %s exceed the limits of a change batch", name)
+		}
+		if records+groupRecords > maxRecords || characters+groupCharacters > maxCharacters {
+			batches = append(batches, batch)
+			batch, records, characters = nil, 0, 0
+		}
+		batch = append(batch, groups[name]...)
+		records += groupRecords
+		characters += groupCharacters
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	for _, b := range batches {
+		sort.SliceStable(b, func(i, j int) bool {
+			return *b[i].Action == route53.ChangeActionDelete && *b[j].Action != route53.ChangeActionDelete
+		})
+	}
+	return batches, nil
+}
+
+// submitChanges previews the changes, asks for confirmation and waits until they are INSYNC.
+func submitChanges(svc *route53.Route53, zone *route53.HostedZone, changes []*route53.Change,
+	replaced []*route53.ResourceRecordSet, comment string) error {
+	if len(changes) == 0 {
+		log.Print("Nothing to change.")
+		return nil
+	}
+	batches, err := splitChanges(changes, maxBatchRecords, maxBatchCharacters)
+	if err != nil {
+		return err
+	}
+	printChanges(zone, changes, replaced)
+	if !utils.AskForConfirmation("Apply the changes?") {
+		log.Print("Aborting...")
+		return nil
+	}
+	for i, batch := range batches {
+		batchComment := comment
+		if len(batches) > 1 {
+			batchComment = fmt.Sprintf("%s (%d/%d)", comment, i+1, len(batches))
+		}
+		resp, err := svc.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+			HostedZoneId: zone.Id,
+			ChangeBatch:  &route53.ChangeBatch{Changes: batch, Comment: aws.String(batchComment)},
+		})
+		if err != nil {
+			return err
+		}
+		// each batch is in sync before the next one.
+		log.Printf("Submitted %s, waiting for the change to be in sync...", *resp.ChangeInfo.Id)
+		err = svc.WaitUntilResourceRecordSetsChanged(&route53.GetChangeInput{Id: resp.ChangeInfo.Id})
+		if err != nil {
+			return err
+		}
+	}
+	log.Print("The change is in sync.")
+	return nil
+}
+
+// SetRecord creates or replaces the record set.
+func SetRecord(change RecordChange) error {
+	svc, err := getRoute53Service()
+	if err != nil {
+		return err
+	}
+	name := normalizeRecordName(change.Name)
+	recordType := strings.ToUpper(change.Type)
+	zone, err := findZone(svc, name, change.Zone)
+	if err != nil {
+		return err
+	}
+	existing, err := getRecordSet(svc, zone, name, recordType)
+	if err != nil {
+		return err
+	}
+	var records []*route53.ResourceRecord
+	for _, v := range change.Values {
+		records = append(records, &route53.ResourceRecord{Value: aws.String(v)})
+	}
+	recordSet := &route53.ResourceRecordSet{
+		Name:            aws.String(name),
+		Type:            aws.String(recordType),
+		TTL:             aws.Int64(change.TTL),
+		ResourceRecords: records,
+	}
+	var replaced []*route53.ResourceRecordSet
+	if existing != nil {
+		if strings.Join(formatRecordSet(existing), "\n") == strings.Join(formatRecordSet(recordSet), "\n") {
+			log.Printf("%s %s is already up to date.", name, recordType)
+			return nil
+		}
+		replaced = append(replaced, existing)
+	}
+	changes := []*route53.Change{{Action: aws.String(route53.ChangeActionUpsert), ResourceRecordSet: recordSet}}
+	return submitChanges(svc, zone, changes, replaced, "bub route53 set")
+}
+
+// DeleteRecord deletes the record set, the values are optional but must match when given.
+func DeleteRecord(change RecordChange) error {
+	svc, err := getRoute53Service()
+	if err != nil {
+		return err
+	}
+	name := normalizeRecordName(change.Name)
+	recordType := strings.ToUpper(change.Type)
+	zone, err := findZone(svc, name, change.Zone)
+	if err != nil {
+		return err
+	}
+	existing, err := getRecordSet(svc, zone, name, recordType)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return fmt.Errorf("no %s record found for %s in %s", recordType, name, *zone.Name)
+	}
+	if len(change.Values) > 0 {
+		var values []string
+		for _, r := range existing.ResourceRecords {
+			values = append(values, *r.Value)
+		}
+		sort.Strings(values)
+		sort.Strings(change.Values)
+		if strings.Join(values, ",") != strings.Join(change.Values, ",") {
+			return fmt.Errorf("the values of %s differ: %s", name, strings.Join(values, ", "))
+		}
+	}
+	changes := []*route53.Change{{Action: aws.String(route53.ChangeActionDelete), ResourceRecordSet: existing}}
+	return submitChanges(svc, zone, changes, nil, "bub route53 delete")
+}
@@ -0,0 +1,351 @@
+package aws
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultTTL is used for the records of the file without a TTL.
+const defaultTTL = 300
+
+type ZoneParams struct {
+	// Zone is the id or the name of the hosted zone.
+	Zone string
+	// Visibility is private or public, required when a private and a public zone share the name.
+	Visibility string
+	// Format is yaml or bind.
+	Format string
+}
+
+// ZoneFile is the declarative description of a zone, the apex SOA and NS records are managed by Route53.
+type ZoneFile struct {
+	Zone    string       `yaml:"zone"`
+	Private bool         `yaml:"private"`
+	Records []ZoneRecord `yaml:"records"`
+}
+
+type ZoneRecord struct {
+	Name          string     `yaml:"name"`
+	Type          string     `yaml:"type"`
+	TTL           int64      `yaml:"ttl,omitempty"`
+	Values        []string   `yaml:"values,omitempty"`
+	Alias         *ZoneAlias `yaml:"alias,omitempty"`
+	SetIdentifier string     `yaml:"setIdentifier,omitempty"`
+	Weight        *int64     `yaml:"weight,omitempty"`
+	Region        string     `yaml:"region,omitempty"`
+	Failover      string     `yaml:"failover,omitempty"`
+	HealthCheckID string     `yaml:"healthCheckId,omitempty"`
+}
+
+type ZoneAlias struct {
+	Target               string `yaml:"target"`
+	ZoneID               string `yaml:"zoneId"`
+	EvaluateTargetHealth bool   `yaml:"evaluateTargetHealth"`
+}
+
+func (r ZoneRecord) key() string {
+	return fmt.Sprintf("%s %s %s", normalizeRecordName(r.Name), strings.ToUpper(r.Type), r.SetIdentifier)
+}
+
+// canonical ignores the order of the values and the case of the alias target.
+func (r ZoneRecord) canonical() string {
+	values := append([]string{}, r.Values...)
+	sort.Strings(values)
+	alias := ""
+	if r.Alias != nil {
+		alias = fmt.Sprintf("%s %s %v", normalizeRecordName(r.Alias.Target), r.Alias.ZoneID, r.Alias.EvaluateTargetHealth)
+	}
+	weight := ""
+	if r.Weight != nil {
+		weight = fmt.Sprintf("%d", *r.Weight)
+	}
+	return strings.Join([]string{r.key(), fmt.Sprintf("%d", r.TTL), strings.Join(values, ","), alias,
+		weight, r.Region, r.Failover, r.HealthCheckID}, "|")
+}
+
+func toZoneRecord(r *route53.ResourceRecordSet) ZoneRecord {
+	record := ZoneRecord{
+		Name:          *r.Name,
+		Type:          *r.Type,
+		TTL:           aws.Int64Value(r.TTL),
+		SetIdentifier: aws.StringValue(r.SetIdentifier),
+		Weight:        r.Weight,
+		Region:        aws.StringValue(r.Region),
+		Failover:      aws.StringValue(r.Failover),
+		HealthCheckID: aws.StringValue(r.HealthCheckId),
+	}
+	for _, v := range r.ResourceRecords {
+		record.Values = append(record.Values, *v.Value)
+	}
+	if r.AliasTarget != nil {
+		record.Alias = &ZoneAlias{
+			Target:               *r.AliasTarget.DNSName,
+			ZoneID:               *r.AliasTarget.HostedZoneId,
+			EvaluateTargetHealth: aws.BoolValue(r.AliasTarget.EvaluateTargetHealth),
+		}
+	}
+	return record
+}
+
+func (r ZoneRecord) toRecordSet() *route53.ResourceRecordSet {
+	recordSet := &route53.ResourceRecordSet{
+		Name:   aws.String(normalizeRecordName(r.Name)),
+		Type:   aws.String(strings.ToUpper(r.Type)),
+		Weight: r.Weight,
+	}
+	if r.Alias != nil {
+		recordSet.AliasTarget = &route53.AliasTarget{
+			DNSName:              aws.String(r.Alias.Target),
+			HostedZoneId:         aws.String(r.Alias.ZoneID),
+			EvaluateTargetHealth: aws.Bool(r.Alias.EvaluateTargetHealth),
+		}
+	} else {
+		recordSet.TTL = aws.Int64(r.TTL)
+		for _, v := range r.Values {
+			recordSet.ResourceRecords = append(recordSet.ResourceRecords, &route53.ResourceRecord{Value: aws.String(v)})
+		}
+	}
+	if r.SetIdentifier != "" {
+		recordSet.SetIdentifier = aws.String(r.SetIdentifier)
+	}
+	if r.Region != "" {
+		recordSet.Region = aws.String(r.Region)
+	}
+	if r.Failover != "" {
+		recordSet.Failover = aws.String(r.Failover)
+	}
+	if r.HealthCheckID != "" {
+		recordSet.HealthCheckId = aws.String(r.HealthCheckID)
+	}
+	return recordSet
+}
+
+// isProtectedRecord is true for the apex SOA and NS records, changing them breaks the delegation.
+func isProtectedRecord(zone *route53.HostedZone, name, recordType string) bool {
+	recordType = strings.ToUpper(recordType)
+	return normalizeRecordName(name) == *zone.Name && (recordType == "SOA" || recordType == "NS")
+}
+
+func getZone(svc *route53.Route53, params ZoneParams) (*route53.HostedZone, error) {
+	zones, err := listHostedZones(svc)
+	if err != nil {
+		return nil, err
+	}
+	var matches []*route53.HostedZone
+	for _, z := range zones {
+		if getZoneID(z) == strings.TrimPrefix(params.Zone, "/hostedzone/") {
+			return z, nil
+		}
+		if *z.Name != normalizeRecordName(params.Zone) {
+			continue
+		}
+		if (params.Visibility == "private" && !isPrivateZone(z)) || (params.Visibility == "public" && isPrivateZone(z)) {
+			continue
+		}
+		matches = append(matches, z)
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no hosted zone found for %s", params.Zone)
+	case 1:
+		return matches[0], nil
+	default:
+		var ids []string
+		for _, z := range matches {
+			ids = append(ids, fmt.Sprintf("%s (private: %v)", getZoneID(z), isPrivateZone(z)))
+		}
+		return nil, fmt.Errorf("several zones are named %s, pick one with --private, --public or the zone id: %s",
+			params.Zone, strings.Join(ids, ", "))
+	}
+}
+
+// getZoneRecords returns the records of the zone, without the protected ones.
+func getZoneRecords(svc *route53.Route53, zone *route53.HostedZone) ([]RecordSet, error) {
+	recordSets, err := listRecordSets(svc, zone)
+	if err != nil {
+		return nil, err
+	}
+	var records []RecordSet
+	for _, r := range recordSets {
+		if !isProtectedRecord(zone, *r.Name, *r.Type) {
+			records = append(records, RecordSet{Zone: zone, RecordSet: r})
+		}
+	}
+	return records, nil
+}
+
+func writeBindZone(w io.Writer, zone *route53.HostedZone, records []RecordSet) error {
+	fmt.Fprintf(w, "$ORIGIN %s\n", *zone.Name)
+	for _, r := range records {
+		rs := r.RecordSet
+		if rs.AliasTarget != nil {
+			// aliases have no BIND equivalent.
+			fmt.Fprintf(w, "; %s ALIAS %s %s\n", *rs.Name, *rs.Type, *rs.AliasTarget.DNSName)
+			continue
+		}
+		if rs.SetIdentifier != nil {
+			fmt.Fprintf(w, "; set identifier: %s\n", *rs.SetIdentifier)
+		}
+		for _, v := range rs.ResourceRecords {
+			if _, err := fmt.Fprintf(w, "%s\t%d\tIN\t%s\t%s\n", *rs.Name, aws.Int64Value(rs.TTL), *rs.Type, *v.Value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ExportZone prints the zone as YAML, to be used with diff and apply, or as a BIND zone file.
+func ExportZone(params ZoneParams) error {
+	svc, err := getRoute53Service()
+	if err != nil {
+		return err
+	}
+	zone, err := getZone(svc, params)
+	if err != nil {
+		return err
+	}
+	records, err := getZoneRecords(svc, zone)
+	if err != nil {
+		return err
+	}
+	switch params.Format {
+	case "bind":
+		return writeBindZone(os.Stdout, zone, records)
+	case "yaml", "":
+		zoneFile := ZoneFile{Zone: *zone.Name, Private: isPrivateZone(zone)}
+		for _, r := range records {
+			zoneFile.Records = append(zoneFile.Records, toZoneRecord(r.RecordSet))
+		}
+		data, err := yaml.Marshal(zoneFile)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	default:
+		return fmt.Errorf("unknown format '%s', use yaml or bind", params.Format)
+	}
+}
+
+func readZoneFile(zoneFilePath string) (*ZoneFile, error) {
+	data, err := ioutil.ReadFile(zoneFilePath)
+	if err != nil {
+		return nil, err
+	}
+	zoneFile := &ZoneFile{}
+	if err = yaml.Unmarshal(data, zoneFile); err != nil {
+		return nil, err
+	}
+	return zoneFile, nil
+}
+
+// getZoneChanges returns the minimal batch to go from the live records to the file, the deletions first
+// so a record can change type, and the live record sets replaced by the upserts.
+func getZoneChanges(zone *route53.HostedZone, live []RecordSet, desired []ZoneRecord) (
+	changes []*route53.Change, replaced []*route53.ResourceRecordSet, err error) {
+	liveRecords := map[string]*route53.ResourceRecordSet{}
+	for _, r := range live {
+		liveRecords[toZoneRecord(r.RecordSet).key()] = r.RecordSet
+	}
+	desiredKeys := map[string]bool{}
+	var upserts []*route53.Change
+	for _, r := range desired {
+		if isProtectedRecord(zone, r.Name, r.Type) {
+			log.Printf("Ignoring %s %s, the apex SOA and NS records are managed by Route53.", r.Name, r.Type)
+			continue
+		}
+		name := normalizeRecordName(r.Name)
+		if name != *zone.Name && !strings.HasSuffix(name, "."+*zone.Name) {
+			return nil, nil, fmt.Errorf("%s is not in the zone %s", r.Name, *zone.Name)
+		}
+		if r.Alias == nil && r.TTL == 0 {
+			r.TTL = defaultTTL
+		}
+		key := r.key()
+		if desiredKeys[key] {
+			return nil, nil, fmt.Errorf("%s is declared more than once", key)
+		}
+		desiredKeys[key] = true
+		existing, ok := liveRecords[key]
+		if ok && toZoneRecord(existing).canonical() == r.canonical() {
+			continue
+		}
+		if ok {
+			replaced = append(replaced, existing)
+		}
+		upserts = append(upserts, &route53.Change{Action: aws.String(route53.ChangeActionUpsert), ResourceRecordSet: r.toRecordSet()})
+	}
+	for _, r := range live {
+		if isProtectedRecord(zone, *r.RecordSet.Name, *r.RecordSet.Type) {
+			continue
+		}
+		if !desiredKeys[toZoneRecord(r.RecordSet).key()] {
+			changes = append(changes, &route53.Change{Action: aws.String(route53.ChangeActionDelete), ResourceRecordSet: r.RecordSet})
+		}
+	}
+	return append(changes, upserts...), replaced, nil
+}
+
+func prepareZoneChanges(params ZoneParams, zoneFilePath string) (
+	*route53.Route53, *route53.HostedZone, []*route53.Change, []*route53.ResourceRecordSet, error) {
+	zoneFile, err := readZoneFile(zoneFilePath)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if params.Zone == "" {
+		params.Zone = zoneFile.Zone
+	}
+	if params.Visibility == "" && zoneFile.Private {
+		params.Visibility = "private"
+	}
+	svc, err := getRoute53Service()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	zone, err := getZone(svc, params)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if zoneFile.Zone != "" && normalizeRecordName(zoneFile.Zone) != *zone.Name {
+		return nil, nil, nil, nil, fmt.Errorf("the file describes %s, not %s", zoneFile.Zone, *zone.Name)
+	}
+	live, err := getZoneRecords(svc, zone)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	changes, replaced, err := getZoneChanges(zone, live, zoneFile.Records)
+	return svc, zone, changes, replaced, err
+}
+
+// DiffZone prints the changes needed for the zone to match the file, true when they differ.
+func DiffZone(params ZoneParams, zoneFilePath string) (bool, error) {
+	_, zone, changes, replaced, err := prepareZoneChanges(params, zoneFilePath)
+	if err != nil {
+		return false, err
+	}
+	if len(changes) == 0 {
+		log.Printf("%s is in sync with %s.", *zone.Name, zoneFilePath)
+		return false, nil
+	}
+	printChanges(zone, changes, replaced)
+	return true, nil
+}
+
+// ApplyZone submits the changes needed for the zone to match the file, in batches within the Route53 limits.
+func ApplyZone(params ZoneParams, zoneFilePath string) error {
+	svc, zone, changes, replaced, err := prepareZoneChanges(params, zoneFilePath)
+	if err != nil {
+		return err
+	}
+	return submitChanges(svc, zone, changes, replaced, "bub route53 apply "+zoneFilePath)
+}
@@ -0,0 +1,129 @@
+package aws
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetZoneChanges(t *testing.T) {
+	t.Parallel()
+	zone := &route53.HostedZone{Name: aws.String("example.com.")}
+	liveRecord := func(name, recordType string, ttl int64, values ...string) RecordSet {
+		return RecordSet{Zone: zone, RecordSet: ZoneRecord{Name: name, Type: recordType, TTL: ttl, Values: values}.toRecordSet()}
+	}
+	live := []RecordSet{
+		liveRecord("example.com.", "NS", 172800, "ns-1.awsdns-00.com."),
+		liveRecord("www.example.com.", "A", 300, "10.0.0.1", "10.0.0.2"),
+		liveRecord("api.example.com.", "A", 300, "10.0.0.3"),
+		liveRecord("old.example.com.", "CNAME", 300, "www.example.com"),
+	}
+	tests := []struct {
+		name     string
+		desired  []ZoneRecord
+		actions  []string
+		replaced int
+		err      bool
+	}{
+		{
+			name: "unchanged, the order of the values and the case are ignored",
+			desired: []ZoneRecord{
+				{Name: "WWW.example.com", Type: "a", Values: []string{"10.0.0.2", "10.0.0.1"}},
+				{Name: "api.example.com.", Type: "A", TTL: 300, Values: []string{"10.0.0.3"}},
+				{Name: "old.example.com", Type: "CNAME", Values: []string{"www.example.com"}},
+			},
+		},
+		{
+			name: "declared twice",
+			desired: []ZoneRecord{
+				{Name: "www.example.com", Type: "A", Values: []string{"10.0.0.1"}},
+				{Name: "www.example.com.", Type: "A", Values: []string{"10.0.0.2"}},
+			},
+			err: true,
+		},
+		{
+			name: "the deletions come first, the apex NS is kept",
+			desired: []ZoneRecord{
+				{Name: "example.com", Type: "NS", Values: []string{"ns.example.net."}},
+				{Name: "www.example.com", Type: "A", Values: []string{"10.0.0.1", "10.0.0.2"}},
+				{Name: "api.example.com", Type: "A", TTL: 60, Values: []string{"10.0.0.3"}},
+				{Name: "new.example.com", Type: "TXT", Values: []string{`"hello"`}},
+			},
+			actions:  []string{"DELETE old.example.com. CNAME", "UPSERT api.example.com. A", "UPSERT new.example.com. TXT"},
+			replaced: 1,
+		},
+		{
+			name:    "outside of the zone",
+			desired: []ZoneRecord{{Name: "www.example.org", Type: "A", Values: []string{"10.0.0.1"}}},
+			err:     true,
+		},
+	}
+	for _, test := range tests {
+		changes, replaced, err := getZoneChanges(zone, live, test.desired)
+		if test.err {
+			assert.Error(t, err, test.name)
+			continue
+		}
+		assert.NoError(t, err, test.name)
+		var actions []string
+		for _, c := range changes {
+			actions = append(actions, *c.Action+" "+*c.ResourceRecordSet.Name+" "+*c.ResourceRecordSet.Type)
+		}
+		assert.Equal(t, test.actions, actions, test.name)
+		assert.Len(t, replaced, test.replaced, test.name)
+	}
+}
+
+func TestSplitChanges(t *testing.T) {
+	t.Parallel()
+	change := func(action, name string, values ...string) *route53.Change {
+		return &route53.Change{Action: aws.String(action), ResourceRecordSet: ZoneRecord{Name: name, Type: "A", TTL: 300, Values: values}.toRecordSet()}
+	}
+	// each upsert counts for 4 values and 40 characters.
+	var changes []*route53.Change
+	for i := 0; i < 300; i++ {
+		changes = append(changes, change(route53.ChangeActionUpsert, fmt.Sprintf("host%d.example.com", i), "10.0.0.100", "10.0.0.101"))
+	}
+	batches, err := splitChanges(changes, maxBatchRecords, maxBatchCharacters)
+	assert.NoError(t, err)
+	assert.Len(t, batches, 2)
+	assert.Len(t, batches[0], 250)
+	assert.Len(t, batches[1], 50)
+
+	batches, err = splitChanges(changes, maxBatchRecords, 1000)
+	assert.NoError(t, err)
+	assert.Len(t, batches, 12)
+	assert.Len(t, batches[0], 25)
+
+	// the deletion stays with the upsert of the same name, before it.
+	changes = []*route53.Change{
+		change(route53.ChangeActionDelete, "old.example.com", "10.0.0.3"),
+		change(route53.ChangeActionDelete, "www.example.com", "10.0.0.1"),
+		change(route53.ChangeActionUpsert, "api.example.com", "10.0.0.4", "10.0.0.5"),
+		change(route53.ChangeActionUpsert, "www.example.com", "10.0.0.2"),
+	}
+	batches, err = splitChanges(changes, 4, maxBatchCharacters)
+	assert.NoError(t, err)
+	var names [][]string
+	for _, b := range batches {
+		var batchNames []string
+		for _, c := range b {
+			batchNames = append(batchNames, *c.Action+" "+*c.ResourceRecordSet.Name)
+		}
+		names = append(names, batchNames)
+	}
+	assert.Equal(t, [][]string{
+		{"DELETE old.example.com.", "DELETE www.example.com.", "UPSERT www.example.com."},
+		{"UPSERT api.example.com."},
+	}, names)
+
+	_, err = splitChanges(changes, 3, maxBatchCharacters)
+	assert.Error(t, err)
+
+	batches, err = splitChanges(nil, maxBatchRecords, maxBatchCharacters)
+	assert.NoError(t, err)
+	assert.Empty(t, batches)
+}
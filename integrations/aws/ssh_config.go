@@ -0,0 +1,140 @@
+package aws
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/user"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/benchlabs/bub/core"
+	"github.com/benchlabs/bub/utils"
+)
+
+const sshConfigHeader = "# Managed by bub, run 'bub ec2 ssh-config' to refresh it. Manual changes will be lost.\n"
+
+var invalidHostChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+type sshHost struct {
+	Aliases                                []string
+	HostName, User, IdentityFile, JumpHost string
+	ProxyCommand                           string
+}
+
+func GetSSHConfigPath() string {
+	usr, _ := user.Current()
+	return path.Join(usr.HomeDir, ".ssh", "bub_config")
+}
+
+// WriteSSHConfig writes a Host entry for every running instance matching the filter, named after
+// the Name tag, and makes sure it is included from '~/.ssh/config'.
+func WriteSSHConfig(cfg *core.Configuration, filter InstanceFilter, configPath string) error {
+	instances := getInstances(cfg, filter)
+	if len(instances) == 0 {
+		return fmt.Errorf("no instances found")
+	}
+	content := renderSSHConfig(buildSSHHosts(cfg, instances))
+	if err := os.MkdirAll(path.Dir(configPath), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(configPath, content, 0600); err != nil {
+		return err
+	}
+	log.Printf("Saved %d hosts to: %v", len(instances), configPath)
+	return ensureSSHInclude(configPath)
+}
+
+func buildSSHHosts(cfg *core.Configuration, instances []*ec2.Instance) []sshHost {
+	counts := map[string]int{}
+	for _, i := range instances {
+		counts[getInstanceName(i)]++
+	}
+	var hosts []sshHost
+	for _, i := range instances {
+		name := invalidHostChars.ReplaceAllString(getInstanceName(i), "-")
+		if name == "" {
+			name = *i.InstanceId
+		} else if counts[getInstanceName(i)] > 1 {
+			// instances of the same environment share the Name tag.
+			name = name + "-" + *i.InstanceId
+		}
+		h := sshHost{
+			Aliases: []string{name},
+			User:    getUsers(i)[0],
+		}
+		if name != *i.InstanceId {
+			h.Aliases = append(h.Aliases, *i.InstanceId)
+		}
+		if i.KeyName != nil {
+			h.IdentityFile = getKeyPath(i)
+		}
+		if useSSM(i, cfg, false) {
+			h.HostName = *i.InstanceId
+			h.ProxyCommand = getSSMProxyCommand(i)
+		} else if i.PublicDnsName != nil && *i.PublicDnsName != "" {
+			h.HostName = *i.PublicDnsName
+		} else if i.PrivateDnsName != nil {
+			h.HostName = *i.PrivateDnsName
+			if jumpHost, ok := findJumpHost(getInstanceName(i), cfg); ok {
+				h.JumpHost = jumpHost
+			} else {
+				log.Printf("No jump host configured for %s, it will only be reachable from the VPC.", name)
+			}
+		}
+		hosts = append(hosts, h)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Aliases[0] < hosts[j].Aliases[0] })
+	return hosts
+}
+
+func renderSSHConfig(hosts []sshHost) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(sshConfigHeader)
+	for _, h := range hosts {
+		fmt.Fprintf(&buf, "\nHost %s\n", strings.Join(h.Aliases, " "))
+		fmt.Fprintf(&buf, "  HostName %s\n", h.HostName)
+		fmt.Fprintf(&buf, "  User %s\n", h.User)
+		if h.IdentityFile != "" {
+			fmt.Fprintf(&buf, "  IdentityFile %s\n", h.IdentityFile)
+		}
+		if h.JumpHost != "" {
+			fmt.Fprintf(&buf, "  ProxyJump %s\n", h.JumpHost)
+		}
+		if h.ProxyCommand != "" {
+			fmt.Fprintf(&buf, "  ProxyCommand %s\n", h.ProxyCommand)
+		}
+	}
+	return buf.Bytes()
+}
+
+// ensureSSHInclude adds the Include to the top of '~/.ssh/config', Include is only global before the first Host.
+func ensureSSHInclude(configPath string) error {
+	usr, _ := user.Current()
+	userConfig := path.Join(usr.HomeDir, ".ssh", "config")
+	content, err := ioutil.ReadFile(userConfig)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	include := "Include " + configPath
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == include {
+			return nil
+		}
+	}
+	if !utils.AskForConfirmation(fmt.Sprintf("Add '%s' to %s?", include, userConfig)) {
+		log.Printf("Add '%s' at the top of %s to use the hosts.", include, userConfig)
+		return nil
+	}
+	if len(content) > 0 {
+		if err = utils.Copy(userConfig, userConfig+"."+utils.CurrentTimeForFilename()+".bak"); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(userConfig, append([]byte(include+"\n\n"), content...), 0600)
+}
@@ -0,0 +1,158 @@
+package aws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/benchlabs/bub/core"
+	"github.com/benchlabs/bub/utils"
+)
+
+// useSSM is true when forced or when the environment of the instance is configured with 'connection: ssm'.
+func useSSM(i *ec2.Instance, cfg *core.Configuration, force bool) bool {
+	if force {
+		return true
+	}
+	name := getInstanceName(i)
+	for _, e := range cfg.AWS.Environments {
+		if strings.HasPrefix(name, e.Prefix) {
+			return e.UsesSSM()
+		}
+	}
+	return false
+}
+
+func getInstanceRegion(i *ec2.Instance) string {
+	az := *i.Placement.AvailabilityZone
+	return az[:len(az)-1]
+}
+
+// getSSMProxyCommand tunnels ssh through SSM, the instance id is the host name.
+func getSSMProxyCommand(i *ec2.Instance) string {
+	return "aws ssm start-session --target %h --document-name AWS-StartSSHSession " +
+		"--parameters portNumber=%p --region " + getInstanceRegion(i)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// getSSMCommand runs the command as the same user as with ssh, SSM sessions start as 'ssm-user'.
+func getSSMCommand(i *ec2.Instance, args []string) string {
+	usr := getUsers(i)[0]
+	if len(args) == 0 {
+		return "sudo su - " + usr
+	}
+	command := strings.Join(prepareRemoteCommand([]string{}, args), " ")
+	return fmt.Sprintf("sudo su - %s -c %s", usr, shellQuote(command))
+}
+
+func connectSSM(i *ec2.Instance, params ConnectionParams) error {
+	if isSCP(params) {
+		return errors.New("scp is not supported with SSM, use 'bub ec2 run' or S3 to copy files")
+	}
+	if params.Output {
+		output, _, err := sendSSMCommand(i, params.Args)
+		outputPath := "output-" + getInstanceName(i) + "-" + utils.CurrentTimeForFilename() + ".txt"
+		if writeErr := ioutil.WriteFile(outputPath, []byte(output), 0644); writeErr != nil {
+			return writeErr
+		}
+		log.Printf("Saved output to: %v", outputPath)
+		return err
+	}
+
+	parameters, err := json.Marshal(map[string][]string{"command": {getSSMCommand(i, params.Args)}})
+	if err != nil {
+		return err
+	}
+	args := []string{
+		"ssm", "start-session",
+		"--region", getInstanceRegion(i),
+		"--target", *i.InstanceId,
+		"--document-name", "AWS-StartInteractiveCommand",
+		"--parameters", string(parameters),
+	}
+	log.Printf("aws %v\n", strings.Join(args, " "))
+	go utils.ConfigureITerm(getInstanceName(i))
+	defer utils.ResetITerm()
+	cmd := exec.Command("aws", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// sendSSMCommand runs the command with SendCommand and waits for the result. SSM truncates
+// the output returned to 24000 characters.
+const (
+	// ssmDeliveryTimeout is the delay for the agent to pick up the command, SSM then times it out.
+	ssmDeliveryTimeout = 5 * time.Minute
+	// ssmExecutionTimeout is the default of AWS-RunShellScript.
+	ssmExecutionTimeout = time.Hour
+	// ssmTimeoutMargin leaves SSM the time to report its own timeouts before giving up.
+	ssmTimeoutMargin = time.Minute
+)
+
+func sendSSMCommand(i *ec2.Instance, args []string) (string, int, error) {
+	config := GetAWSConfig(getInstanceRegion(i))
+	sess, err := session.NewSession(&config)
+	if err != nil {
+		return "", -1, err
+	}
+	svc := ssm.New(sess)
+	resp, err := svc.SendCommand(&ssm.SendCommandInput{
+		InstanceIds:    []*string{i.InstanceId},
+		DocumentName:   aws.String("AWS-RunShellScript"),
+		Comment:        aws.String("bub ec2"),
+		TimeoutSeconds: aws.Int64(int64(ssmDeliveryTimeout.Seconds())),
+		Parameters: map[string][]*string{
+			"commands":         {aws.String(getSSMCommand(i, args))},
+			"executionTimeout": {aws.String(fmt.Sprint(int64(ssmExecutionTimeout.Seconds())))},
+		},
+	})
+	if err != nil {
+		return "", -1, err
+	}
+
+	input := &ssm.GetCommandInvocationInput{CommandId: resp.Command.CommandId, InstanceId: i.InstanceId}
+	deadline := time.Now().Add(ssmDeliveryTimeout + ssmExecutionTimeout + ssmTimeoutMargin)
+	for {
+		if time.Now().After(deadline) {
+			svc.CancelCommand(&ssm.CancelCommandInput{CommandId: resp.Command.CommandId, InstanceIds: []*string{i.InstanceId}})
+			return "", -1, fmt.Errorf("the command %s did not complete after %v", *resp.Command.CommandId,
+				ssmDeliveryTimeout+ssmExecutionTimeout)
+		}
+		time.Sleep(2 * time.Second)
+		invocation, err := svc.GetCommandInvocation(input)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeInvocationDoesNotExist {
+			// the invocation is not visible right after sending the command.
+			continue
+		} else if err != nil {
+			return "", -1, err
+		}
+		switch *invocation.Status {
+		case ssm.CommandInvocationStatusPending, ssm.CommandInvocationStatusInProgress,
+			ssm.CommandInvocationStatusDelayed:
+			continue
+		}
+		output := aws.StringValue(invocation.StandardOutputContent) + aws.StringValue(invocation.StandardErrorContent)
+		code := int(aws.Int64Value(invocation.ResponseCode))
+		if *invocation.Status != ssm.CommandInvocationStatusSuccess {
+			return output, code, fmt.Errorf("the command %s: %s",
+				strings.ToLower(*invocation.Status), aws.StringValue(invocation.StatusDetails))
+		}
+		return output, code, nil
+	}
+}
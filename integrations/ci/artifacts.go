@@ -0,0 +1,343 @@
+package ci
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Artifact is a build artifact of any of the CI backends.
+type Artifact struct {
+	// Path is relative to the build, it is used for the selection and as the destination.
+	Path string
+	URL  string
+	// Size is -1 when unknown, the Content-Length is then used.
+	Size int64
+	// Checksum is 'algorithm:hex digest', md5, sha1 or sha256, empty when unknown.
+	Checksum string
+	// Header holds the credentials, they are never put in the URL nor sent to another host on redirects.
+	Header http.Header
+}
+
+type ArtifactParams struct {
+	// Patterns are globs matched against the path or the file name, everything is selected when empty.
+	Patterns []string
+	Dir      string
+	Parallel int
+	// List only prints the selected artifacts.
+	List bool
+	// Force downloads the files already present.
+	Force bool
+}
+
+func matchArtifact(artifactPath string, patterns []string) (bool, error) {
+	if len(patterns) == 0 {
+		return true, nil
+	}
+	for _, p := range patterns {
+		for _, name := range []string{artifactPath, path.Base(artifactPath)} {
+			match, err := path.Match(p, name)
+			if err != nil {
+				return false, fmt.Errorf("invalid pattern '%s': %v", p, err)
+			}
+			if match {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// SelectArtifacts returns the artifacts matching any of the patterns.
+func SelectArtifacts(artifacts []Artifact, patterns []string) ([]Artifact, error) {
+	var selected []Artifact
+	for _, a := range artifacts {
+		match, err := matchArtifact(a.Path, patterns)
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			selected = append(selected, a)
+		}
+	}
+	return selected, nil
+}
+
+func formatSize(size int64) string {
+	if size < 0 {
+		return "?"
+	}
+	units := []string{"B", "KB", "MB", "GB"}
+	value := float64(size)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[i])
+}
+
+func newChecksumHash(checksum string) (hash.Hash, string, error) {
+	if checksum == "" {
+		return nil, "", nil
+	}
+	parts := strings.SplitN(checksum, ":", 2)
+	if len(parts) != 2 {
+		return nil, "", fmt.Errorf("invalid checksum '%s'", checksum)
+	}
+	switch parts[0] {
+	case "md5":
+		return md5.New(), strings.ToLower(parts[1]), nil
+	case "sha1":
+		return sha1.New(), strings.ToLower(parts[1]), nil
+	case "sha256":
+		return sha256.New(), strings.ToLower(parts[1]), nil
+	default:
+		return nil, "", fmt.Errorf("unsupported checksum algorithm '%s'", parts[0])
+	}
+}
+
+// verifyFile checks the size and the checksum of a local file, when they are known.
+func verifyFile(filePath string, size int64, checksum string) (bool, error) {
+	info, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if size >= 0 && info.Size() != size {
+		return false, nil
+	}
+	h, expected, err := newChecksumHash(checksum)
+	if err != nil || h == nil {
+		// without a size nor a checksum the file could be truncated.
+		return size >= 0, err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if _, err = io.Copy(h, f); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)) == expected, nil
+}
+
+func newArtifactRequest(method string, a Artifact) (*http.Request, error) {
+	req, err := http.NewRequest(method, a.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, values := range a.Header {
+		for _, v := range values {
+			req.Header.Add(k, v)
+		}
+	}
+	return req, nil
+}
+
+// progressInterval is the delay between the progress logs of a download.
+var progressInterval = 10 * time.Second
+
+// getArtifactClient drops the credentials when redirected to another host, e.g. the storage of the artifacts.
+// The standard client only drops the Authorization header, not the custom ones like Circle-Token.
+func getArtifactClient(a Artifact) *http.Client {
+	return &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if req.URL.Host != via[0].URL.Host {
+			for k := range a.Header {
+				req.Header.Del(k)
+			}
+		}
+		return nil
+	}}
+}
+
+// progressWriter logs the bytes written every progressInterval, for the large artifacts.
+type progressWriter struct {
+	path    string
+	size    int64
+	written int64
+	last    time.Time
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	if time.Since(p.last) >= progressInterval {
+		p.last = time.Now()
+		log.Printf("%s: %s of %s", p.path, formatSize(p.written), formatSize(p.size))
+	}
+	return len(b), nil
+}
+
+func getArtifactSize(a Artifact) (int64, error) {
+	if a.Size >= 0 {
+		return a.Size, nil
+	}
+	req, err := newArtifactRequest(http.MethodHead, a)
+	if err != nil {
+		return -1, err
+	}
+	resp, err := getArtifactClient(a).Do(req)
+	if err != nil {
+		return -1, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// some servers do not support HEAD, the size is checked after the download.
+		return -1, nil
+	}
+	return resp.ContentLength, nil
+}
+
+// downloadArtifact writes to a temporary file, renamed once the size and the checksum are verified.
+func downloadArtifact(a Artifact, dest string, force bool) (skipped bool, size int64, err error) {
+	size, err = getArtifactSize(a)
+	if err != nil {
+		return false, 0, err
+	}
+	if !force {
+		present, err := verifyFile(dest, size, a.Checksum)
+		if err != nil {
+			return false, 0, err
+		}
+		if present {
+			return true, size, nil
+		}
+	}
+	if err = os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return false, 0, err
+	}
+	req, err := newArtifactRequest(http.MethodGet, a)
+	if err != nil {
+		return false, 0, err
+	}
+	resp, err := getArtifactClient(a).Do(req)
+	if err != nil {
+		return false, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, 0, fmt.Errorf("%s: %s", a.Path, resp.Status)
+	}
+
+	tmp := dest + ".part"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return false, 0, err
+	}
+	defer os.Remove(tmp)
+	h, expected, err := newChecksumHash(a.Checksum)
+	if err != nil {
+		out.Close()
+		return false, 0, err
+	}
+	if size < 0 {
+		size = resp.ContentLength
+	}
+	writers := []io.Writer{out, &progressWriter{path: a.Path, size: size, last: time.Now()}}
+	if h != nil {
+		writers = append(writers, h)
+	}
+	w := io.MultiWriter(writers...)
+	written, err := io.Copy(w, resp.Body)
+	out.Close()
+	if err != nil {
+		return false, 0, err
+	}
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return false, 0, fmt.Errorf("%s: got %d bytes, expected %d", a.Path, written, resp.ContentLength)
+	}
+	if a.Size >= 0 && written != a.Size {
+		return false, 0, fmt.Errorf("%s: got %d bytes, expected %d", a.Path, written, a.Size)
+	}
+	if h != nil && hex.EncodeToString(h.Sum(nil)) != expected {
+		return false, 0, fmt.Errorf("%s: checksum mismatch, expected %s", a.Path, a.Checksum)
+	}
+	return false, written, os.Rename(tmp, dest)
+}
+
+func listArtifacts(artifacts []Artifact) error {
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "Path\tSize\tChecksum")
+	for _, a := range artifacts {
+		fmt.Fprintf(table, "%s\t%s\t%s\n", a.Path, formatSize(a.Size), a.Checksum)
+	}
+	return table.Flush()
+}
+
+// DownloadArtifacts selects the artifacts and downloads them in parallel, keeping their relative path.
+func DownloadArtifacts(artifacts []Artifact, params ArtifactParams) error {
+	selected, err := SelectArtifacts(artifacts, params.Patterns)
+	if err != nil {
+		return err
+	}
+	if len(selected) == 0 {
+		return fmt.Errorf("no artifacts matching '%s' out of %d", strings.Join(params.Patterns, "', '"), len(artifacts))
+	}
+	if params.List {
+		return listArtifacts(selected)
+	}
+	if params.Dir == "" {
+		params.Dir = "."
+	}
+	if params.Parallel < 1 {
+		params.Parallel = 1
+	}
+
+	var lock sync.Mutex
+	var failed []string
+	done := 0
+	sem := make(chan bool, params.Parallel)
+	wg := sync.WaitGroup{}
+	for _, a := range selected {
+		wg.Add(1)
+		sem <- true
+		go func(a Artifact) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			dest := filepath.Join(params.Dir, filepath.FromSlash(path.Clean("/"+a.Path)))
+			skipped, size, err := downloadArtifact(a, dest, params.Force)
+			lock.Lock()
+			defer lock.Unlock()
+			done++
+			progress := fmt.Sprintf("[%d/%d]", done, len(selected))
+			switch {
+			case err != nil:
+				failed = append(failed, a.Path)
+				log.Printf("%s failed: %v", progress, err)
+			case skipped:
+				log.Printf("%s %s already present, skipping.", progress, dest)
+			default:
+				log.Printf("%s %s (%s)", progress, dest, formatSize(size))
+			}
+		}(a)
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		return errors.New("failed to download: " + strings.Join(failed, ", "))
+	}
+	return nil
+}
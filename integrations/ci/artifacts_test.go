@@ -0,0 +1,52 @@
+package ci
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelectArtifacts(t *testing.T) {
+	t.Parallel()
+	artifacts := []Artifact{{Path: "target/app.jar"}, {Path: "reports/unit.xml"}, {Path: "screenshots/home.png"}}
+
+	selected, err := SelectArtifacts(artifacts, []string{"*.jar", "reports/*.xml"})
+	assert.Nil(t, err)
+	assert.Equal(t, []Artifact{artifacts[0], artifacts[1]}, selected)
+
+	selected, err = SelectArtifacts(artifacts, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, artifacts, selected)
+
+	_, err = SelectArtifacts(artifacts, []string{"["})
+	assert.NotNil(t, err)
+}
+
+func TestDownloadArtifactRedirect(t *testing.T) {
+	t.Parallel()
+	var storageToken string
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		storageToken = r.Header.Get("Circle-Token")
+		w.Write([]byte("content"))
+	}))
+	defer storage.Close()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("Circle-Token"))
+		http.Redirect(w, r, storage.URL+"/app.jar", http.StatusFound)
+	}))
+	defer api.Close()
+
+	dir, err := ioutil.TempDir("", "bub-artifacts")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dest := filepath.Join(dir, "app.jar")
+	a := Artifact{Path: "app.jar", URL: api.URL + "/app.jar", Size: 7, Header: http.Header{"Circle-Token": {"secret"}}}
+	_, size, err := downloadArtifact(a, dest, false)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), size)
+	assert.Equal(t, "", storageToken)
+}
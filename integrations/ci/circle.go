@@ -7,7 +7,6 @@ import (
 	"net/url"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/benchlabs/bub/core"
 	"github.com/benchlabs/bub/utils"
@@ -49,6 +48,22 @@ func MustInitCircle(cfg *core.Configuration) *Circle {
 	return &Circle{cfg, &circleci.Client{Token: token}}
 }
 
+// CheckCircle validates the token and returns the login it belongs to.
+func CheckCircle(cfg *core.Configuration) (string, error) {
+	token := cfg.Circle.Token
+	if token == "" || strings.HasPrefix(token, "<optional-") {
+		token = os.Getenv("CIRCLE_TOKEN")
+	}
+	if token == "" {
+		return "", errors.New("token not configured")
+	}
+	user, err := (&circleci.Client{Token: token}).Me()
+	if err != nil {
+		return "", err
+	}
+	return user.Login, nil
+}
+
 func OpenCircle(cfg *core.Configuration, m *core.Manifest, getBranch bool) error {
 	base := "https://circleci.com/gh/" + cfg.GitHub.Organization
 	if getBranch {
@@ -58,43 +73,6 @@ func OpenCircle(cfg *core.Configuration, m *core.Manifest, getBranch bool) error
 	return utils.OpenURI(base, m.Repository)
 }
 
-func (c *Circle) TriggerAndWaitForSuccess(m *core.Manifest) error {
-	b, err := c.client.Build(c.cfg.GitHub.Organization, m.Repository, m.Branch)
-	if err != nil {
-		return err
-	}
-
-	log.Printf("Triggered b: %s", b.BuildURL)
-	time.Sleep(1 * time.Second)
-
-	for {
-		b, err = c.client.GetBuild(c.cfg.GitHub.Organization, m.Repository, b.BuildNum)
-		if err != nil {
-			return err
-		}
-
-		if isFinished(b) {
-			break
-		}
-		log.Printf("Current lifecycle state: %s, waiting 20s...", b.Lifecycle)
-		time.Sleep(20 * time.Second)
-	}
-	return isSuccess(b)
-}
-
-func isSuccess(b *circleci.Build) error {
-	if b.Outcome == "success" {
-		log.Printf("The build succeeded! %v", b.BuildURL)
-		return nil
-	} else {
-		return errors.New(fmt.Sprintf("the build failed: %s, %s", b.Outcome, b.BuildURL))
-	}
-}
-
-func isFinished(build *circleci.Build) bool {
-	return utils.Contains(build.Lifecycle, "finished", "not_run")
-}
-
 func configurationExist() (bool, error) {
 	legacyConfiguration, err := utils.PathExists("circle.yml")
 	if err != nil {
@@ -114,91 +92,56 @@ func configurationExist() (bool, error) {
 }
 
 func (c *Circle) CheckBuildStatus(m *core.Manifest) error {
-	b, err := c.GetCompletedBuild(m)
+	pipeline, err := c.getConfiguredPipeline(m)
 	if err != nil {
 		if _, ok := err.(*CircleCINotConfiguredError); ok {
 			return nil
 		}
 		return err
 	}
-	return isSuccess(b)
+	workflows, err := c.waitForWorkflows(pipeline, nil, nil)
+	if err != nil {
+		return err
+	}
+	if err = checkWorkflows(workflows); err != nil {
+		return err
+	}
+	log.Printf("The pipeline succeeded! https://app.circleci.com/pipelines/%s/%d", c.getProjectSlug(m), pipeline.Number)
+	return nil
 }
 
-func (c *Circle) GetCompletedBuild(m *core.Manifest) (*circleci.Build, error) {
-	var build *circleci.Build
+// getConfiguredPipeline returns the pipeline of the current commit, or a CircleCINotConfiguredError.
+func (c *Circle) getConfiguredPipeline(m *core.Manifest) (*Pipeline, error) {
 	_, err := configurationExist()
 	if err != nil {
-		return build, err
-	}
-	p, err := c.client.FollowProject(c.cfg.GitHub.Organization, m.Repository)
-	if err != nil && !strings.HasPrefix(err.Error(), "403") {
-		return build, err
-	} else if p == nil {
-		if err != nil {
-			log.Printf("API Error: %v", err)
-		}
-		errMsg := "CircleCI not configured or the current user has no access to the project."
-		log.Printf("%s Skipping check...", errMsg)
-		return build, NewCircleCINotConfiguredError(errMsg)
+		return nil, err
 	}
 	head, err := core.MustInitGit(".").CurrentHEAD()
-	if err != nil {
-		return build, err
-	}
-	log.Printf("Commit: %v", head)
-	for {
-		build, err = c.checkBuildStatus(head, m)
-		if err != nil {
-			return build, err
-		}
-		if isFinished(build) {
-			break
-		}
-		log.Printf("Status: '%v', waiting 10s. %v", build.Status, build.BuildURL)
-		time.Sleep(10 * time.Second)
-	}
-	return build, nil
-}
-
-func (c *Circle) checkBuildStatus(head string, m *core.Manifest) (*circleci.Build, error) {
-	builds, err := c.client.ListRecentBuildsForProject(c.cfg.GitHub.Organization, m.Repository, m.Branch, "", 50, 0)
 	if err != nil {
 		return nil, err
 	}
-	for _, b := range builds {
-		commit := b.VcsRevision
-		if commit == head {
-			return b, nil
-		}
+	log.Printf("Commit: %v", head)
+	pipeline, err := c.findPipeline(m, m.Branch, head)
+	if err == circleNotFound {
+		errMsg := "CircleCI not configured or the current user has no access to the project."
+		log.Printf("%s Skipping check...", errMsg)
+		return nil, NewCircleCINotConfiguredError(errMsg)
 	}
-	return nil, NoBuildFound
+	return pipeline, err
 }
 
-func (c *Circle) DownloadArtifact(m *core.Manifest, fname, path string) error {
-	b, err := c.GetCompletedBuild(m)
+// GetArtifacts downloads the artifacts of the pipeline of the current commit once it is completed.
+func (c *Circle) GetArtifacts(m *core.Manifest, params ArtifactParams) error {
+	pipeline, err := c.getConfiguredPipeline(m)
 	if err != nil {
 		return err
 	}
-
-	arr, err := getBuildArtifacts(c.client, c.GetAccount(), m.Repository, b)
-	if err != nil {
+	if _, err = c.waitForWorkflows(pipeline, nil, nil); err != nil {
 		return err
 	}
-
-	for _, a := range arr {
-		if strings.Contains(a.URL, fname) {
-			return utils.DownloadFile(path, fmt.Sprintf("%s?circle-token=%s", a.URL, c.client.Token))
-		}
-	}
-
-	return nil
-}
-
-func getBuildArtifacts(c *circleci.Client, account, repo string, b *circleci.Build) ([]*circleci.Artifact, error) {
-	var arr []*circleci.Artifact
-	arr, err := c.ListBuildArtifacts(account, repo, b.BuildNum)
+	artifacts, err := c.getPipelineArtifacts(m, pipeline)
 	if err != nil {
-		return arr, err
+		return err
 	}
-	return arr, nil
+	return DownloadArtifacts(artifacts, params)
 }
@@ -0,0 +1,253 @@
+package ci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/benchlabs/bub/core"
+)
+
+type RerunParams struct {
+	// Workflow is the name of the workflow, all the failed ones by default.
+	Workflow string
+	// FailedOnly reruns only the failed jobs, FromFailed the failed jobs and the ones depending on them.
+	FailedOnly, FromFailed bool
+	Async                  bool
+}
+
+func isWorkflowFailed(status string) bool {
+	return status == "failed" || status == "error" || status == "canceled"
+}
+
+func isJobFailed(status string) bool {
+	return status == "failed" || status == "infrastructure_fail" || status == "timedout"
+}
+
+// RerunWorkflows reruns the failed workflows of the current commit's pipeline and follows them.
+func (c *Circle) RerunWorkflows(m *core.Manifest, params RerunParams) error {
+	if params.FailedOnly && params.FromFailed {
+		return errors.New("pick either the failed jobs only or from the failed jobs")
+	}
+	pipeline, err := c.getConfiguredPipeline(m)
+	if err != nil {
+		return err
+	}
+	workflows, err := c.getWorkflows(pipeline)
+	if err != nil {
+		return err
+	}
+	// the reruns are new workflows, they replace the previous ones once created.
+	rerunIDs := map[string]string{}
+	for _, wf := range workflows {
+		if params.Workflow != "" && wf.Name != params.Workflow {
+			continue
+		}
+		if params.Workflow == "" && !isWorkflowFailed(wf.Status) {
+			continue
+		}
+		if isWorkflowRunning(wf.Status) {
+			return fmt.Errorf("the workflow %s is still running", wf.Name)
+		}
+		body := map[string]interface{}{}
+		if params.FromFailed {
+			body["from_failed"] = true
+		} else if params.FailedOnly {
+			jobs, err := c.getJobs(wf)
+			if err != nil {
+				return err
+			}
+			var failed []string
+			for _, j := range jobs {
+				if isJobFailed(j.Status) {
+					failed = append(failed, j.ID)
+				}
+			}
+			if len(failed) == 0 {
+				return fmt.Errorf("the workflow %s has no failed jobs", wf.Name)
+			}
+			body["jobs"] = failed
+		}
+		var result struct {
+			WorkflowID string `json:"workflow_id"`
+		}
+		if err = c.doV2(http.MethodPost, "/workflow/"+wf.ID+"/rerun", nil, body, &result); err != nil {
+			return err
+		}
+		log.Printf("Rerunning %s (%s).", wf.Name, wf.Status)
+		rerunIDs[wf.Name] = result.WorkflowID
+	}
+	if len(rerunIDs) == 0 {
+		if params.Workflow != "" {
+			return fmt.Errorf("no workflow named %s in pipeline #%d", params.Workflow, pipeline.Number)
+		}
+		return fmt.Errorf("no failed workflow in pipeline #%d", pipeline.Number)
+	}
+	if params.Async {
+		return nil
+	}
+	return c.waitAndShowPipeline(m, pipeline, func(wf Workflow) bool {
+		id, ok := rerunIDs[wf.Name]
+		return ok && id != "" && wf.ID != id
+	})
+}
+
+// ApproveJob approves the held job of the current commit's pipeline and follows the workflow.
+// The job name is only required when several jobs are on hold.
+func (c *Circle) ApproveJob(m *core.Manifest, name string, async bool) error {
+	pipeline, err := c.getConfiguredPipeline(m)
+	if err != nil {
+		return err
+	}
+	workflows, err := c.getWorkflows(pipeline)
+	if err != nil {
+		return err
+	}
+	type heldJob struct {
+		workflow Workflow
+		job      CircleJob
+	}
+	var held []heldJob
+	for _, wf := range workflows {
+		if wf.Status != "on_hold" {
+			continue
+		}
+		jobs, err := c.getJobs(wf)
+		if err != nil {
+			return err
+		}
+		for _, j := range jobs {
+			if j.Type == "approval" && j.Status == "on_hold" && (name == "" || j.Name == name) {
+				held = append(held, heldJob{wf, j})
+			}
+		}
+	}
+	switch {
+	case len(held) == 0 && name != "":
+		return fmt.Errorf("no job named %s is waiting for an approval", name)
+	case len(held) == 0:
+		return errors.New("no job is waiting for an approval")
+	case len(held) > 1:
+		var names []string
+		for _, h := range held {
+			names = append(names, h.workflow.Name+"/"+h.job.Name)
+		}
+		return fmt.Errorf("several jobs are waiting for an approval, pick one: %s", strings.Join(names, ", "))
+	}
+
+	h := held[0]
+	requestID := h.job.ApprovalRequestID
+	if requestID == "" {
+		requestID = h.job.ID
+	}
+	if err = c.doV2(http.MethodPost, "/workflow/"+h.workflow.ID+"/approve/"+requestID, nil, nil, nil); err != nil {
+		return err
+	}
+	log.Printf("Approved %s in %s.", h.job.Name, h.workflow.Name)
+	if async {
+		return nil
+	}
+	// the workflow stays on hold for a few seconds after the approval.
+	resumed := false
+	return c.waitAndShowPipeline(m, pipeline, func(wf Workflow) bool {
+		if wf.ID != h.workflow.ID {
+			return false
+		}
+		resumed = resumed || wf.Status != "on_hold"
+		return !resumed
+	})
+}
+
+// getV1Project returns the account and the repository for the API v1.1, the only one with the step logs.
+func (c *Circle) getV1Project(m *core.Manifest) (string, string, bool) {
+	parts := strings.Split(c.getProjectSlug(m), "/")
+	if len(parts) != 3 || (parts[0] != "gh" && parts[0] != "github") {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+func getStepOutput(outputURL string) (string, error) {
+	resp, err := http.Get(outputURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch the step output: %s", resp.Status)
+	}
+	var messages []struct {
+		Message string `json:"message"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&messages); err != nil {
+		return "", err
+	}
+	var output []string
+	for _, msg := range messages {
+		output = append(output, msg.Message)
+	}
+	return strings.Join(output, ""), nil
+}
+
+// stepsPrinter prints the output of the failed steps of each job once, as soon as the job fails.
+type stepsPrinter struct {
+	circle        *Circle
+	w             io.Writer
+	account, repo string
+	printed       map[string]bool
+}
+
+// newStepsPrinter returns nil when the step logs are not available for the project.
+func (c *Circle) newStepsPrinter(w io.Writer, m *core.Manifest) *stepsPrinter {
+	account, repo, ok := c.getV1Project(m)
+	if !ok {
+		log.Printf("The step logs are only available for the GitHub OAuth projects, not %s, see the web UI.", c.getProjectSlug(m))
+		return nil
+	}
+	return &stepsPrinter{circle: c, w: w, account: account, repo: repo, printed: map[string]bool{}}
+}
+
+// printFailedJobs shows the output of the failed steps of the jobs not shown yet, the errors are only logged.
+func (p *stepsPrinter) printFailedJobs(workflows []Workflow) {
+	if p == nil {
+		return
+	}
+	for _, wf := range workflows {
+		if !isWorkflowRunning(wf.Status) && !isWorkflowFailed(wf.Status) {
+			continue
+		}
+		jobs, err := p.circle.getJobs(wf)
+		if err != nil {
+			log.Printf("Failed to fetch the jobs of %s: %v", wf.Name, err)
+			continue
+		}
+		for _, j := range jobs {
+			if !isJobFailed(j.Status) || p.printed[j.ID] {
+				continue
+			}
+			p.printed[j.ID] = true
+			build, err := p.circle.client.GetBuild(p.account, p.repo, int(j.JobNumber))
+			if err != nil {
+				log.Printf("Failed to fetch the steps of %s: %v", j.Name, err)
+				continue
+			}
+			for _, step := range build.Steps {
+				for _, action := range step.Actions {
+					if action.Status != "failed" || action.OutputURL == "" {
+						continue
+					}
+					output, err := getStepOutput(action.OutputURL)
+					if err != nil {
+						log.Printf("%s: %v", step.Name, err)
+						continue
+					}
+					fmt.Fprintf(p.w, "\n=== %s / %s: %s\n%s\n", wf.Name, j.Name, step.Name, strings.TrimRight(output, "\n"))
+				}
+			}
+		}
+	}
+}
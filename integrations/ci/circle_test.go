@@ -0,0 +1,35 @@
+package ci
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSortJobs(t *testing.T) {
+	t.Parallel()
+	jobs := []CircleJob{
+		{ID: "3", Name: "deploy", Dependencies: []string{"2", "4"}},
+		{ID: "2", Name: "test", Dependencies: []string{"1"}},
+		{ID: "1", Name: "build"},
+		{ID: "4", Name: "approve"},
+	}
+	var names []string
+	for _, j := range sortJobs(jobs) {
+		names = append(names, j.Name)
+	}
+	assert.Equal(t, []string{"approve", "build", "test", "deploy"}, names)
+}
+
+func TestCheckPipeline(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	pipeline := &Pipeline{Number: 7, State: "created", CreatedAt: now.Add(-time.Minute)}
+	assert.NoError(t, checkPipeline(pipeline, nil, now))
+	assert.Error(t, checkPipeline(pipeline, nil, now.Add(workflowsTimeout)))
+	assert.NoError(t, checkPipeline(pipeline, []Workflow{{Name: "build"}}, now.Add(workflowsTimeout)))
+
+	pipeline.State = "errored"
+	pipeline.Errors = []PipelineError{{Type: "config", Message: "Unknown job: deploy"}}
+	assert.EqualError(t, checkPipeline(pipeline, nil, now), "pipeline #7 errored: config: Unknown job: deploy")
+}
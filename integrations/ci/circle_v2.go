@@ -0,0 +1,475 @@
+package ci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/benchlabs/bub/core"
+	"github.com/benchlabs/bub/utils"
+)
+
+const (
+	circleAPI = "https://circleci.com/api/v2"
+	// maxPipelinePages bounds the search of the pipeline of a commit, 20 pipelines per page.
+	maxPipelinePages = 5
+	// workflowsTimeout is how long a pipeline can go without creating any workflow, e.g. every workflow filtered out.
+	workflowsTimeout = 2 * time.Minute
+)
+
+// circleNotFound is returned for the unknown projects and pipelines, and the ones the token can't see.
+var circleNotFound = errors.New("not found on CircleCI")
+
+type Pipeline struct {
+	ID     string `json:"id"`
+	Number int64  `json:"number"`
+	// State is errored when the configuration is invalid, no workflow is created then.
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"created_at"`
+	VCS       struct {
+		Branch   string `json:"branch"`
+		Revision string `json:"revision"`
+	} `json:"vcs"`
+	Errors []PipelineError `json:"errors"`
+}
+
+type PipelineError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+type Workflow struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	Status         string     `json:"status"`
+	PipelineNumber int64      `json:"pipeline_number"`
+	CreatedAt      time.Time  `json:"created_at"`
+	StoppedAt      *time.Time `json:"stopped_at"`
+}
+
+type CircleJob struct {
+	ID                string     `json:"id"`
+	Name              string     `json:"name"`
+	Status            string     `json:"status"`
+	Type              string     `json:"type"`
+	JobNumber         int64      `json:"job_number"`
+	Dependencies      []string   `json:"dependencies"`
+	StartedAt         *time.Time `json:"started_at"`
+	StoppedAt         *time.Time `json:"stopped_at"`
+	ApprovalRequestID string     `json:"approval_request_id"`
+}
+
+type PipelineParams struct {
+	Branch string
+	// Parameters are converted to booleans and integers when they look like one.
+	Parameters map[string]string
+	Async      bool
+}
+
+// isWorkflowRunning is false for the workflows waiting for an approval, nothing happens until someone approves.
+func isWorkflowRunning(status string) bool {
+	return status == "running" || status == "failing"
+}
+
+func isWorkflowSuccess(status string) bool {
+	return status == "success"
+}
+
+// isGitHubAppSlug is true for the projects of the GitHub App, they have no VCS and organization in the slug.
+func isGitHubAppSlug(slug string) bool {
+	return strings.HasPrefix(slug, "circleci/")
+}
+
+// getProjectSlug uses the manifest slug for the projects of the GitHub App, e.g. circleci/<org-id>/<project-id>.
+func (c *Circle) getProjectSlug(m *core.Manifest) string {
+	if m.Circle.Slug != "" {
+		return m.Circle.Slug
+	}
+	return "gh/" + c.cfg.GitHub.Organization + "/" + m.Repository
+}
+
+func (c *Circle) doV2(method, endpoint string, query url.Values, body, result interface{}) error {
+	uri := circleAPI + endpoint
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+	err := utils.DoJSON(method, uri, http.Header{"Circle-Token": {c.client.Token}}, body, result)
+	if e, ok := err.(*utils.HTTPError); ok && e.StatusCode == http.StatusNotFound {
+		return circleNotFound
+	}
+	return err
+}
+
+// listV2 follows the next_page_token until fn returns false or there are no more pages.
+func (c *Circle) listV2(endpoint string, query url.Values, fn func(items json.RawMessage) (bool, error)) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	for {
+		var page struct {
+			Items         json.RawMessage `json:"items"`
+			NextPageToken string          `json:"next_page_token"`
+		}
+		if err := c.doV2(http.MethodGet, endpoint, query, nil, &page); err != nil {
+			return err
+		}
+		more, err := fn(page.Items)
+		if err != nil || !more || page.NextPageToken == "" {
+			return err
+		}
+		query.Set("page-token", page.NextPageToken)
+	}
+}
+
+func toPipelineParameters(params map[string]string) map[string]interface{} {
+	converted := map[string]interface{}{}
+	for k, v := range params {
+		if v == "true" || v == "false" {
+			converted[k] = v == "true"
+		} else if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			converted[k] = i
+		} else {
+			converted[k] = v
+		}
+	}
+	return converted
+}
+
+func (c *Circle) getPipeline(m *core.Manifest, number int64) (*Pipeline, error) {
+	pipeline := &Pipeline{}
+	endpoint := fmt.Sprintf("/project/%s/pipeline/%d", c.getProjectSlug(m), number)
+	return pipeline, c.doV2(http.MethodGet, endpoint, nil, nil, pipeline)
+}
+
+func (c *Circle) refreshPipeline(pipeline *Pipeline) error {
+	return c.doV2(http.MethodGet, "/pipeline/"+pipeline.ID, nil, nil, pipeline)
+}
+
+// checkPipeline fails for the errored pipelines, and the ones without workflow after the workflowsTimeout.
+func checkPipeline(pipeline *Pipeline, workflows []Workflow, now time.Time) error {
+	if pipeline.State == "errored" {
+		var messages []string
+		for _, e := range pipeline.Errors {
+			messages = append(messages, e.Type+": "+e.Message)
+		}
+		return fmt.Errorf("pipeline #%d errored: %s", pipeline.Number, strings.Join(messages, ", "))
+	}
+	if len(workflows) == 0 && !pipeline.CreatedAt.IsZero() && now.Sub(pipeline.CreatedAt) > workflowsTimeout {
+		return fmt.Errorf("pipeline #%d created no workflow after %v, check the filters of the workflows", pipeline.Number, workflowsTimeout)
+	}
+	return nil
+}
+
+// findPipeline returns the most recent pipeline of the commit among the recent pipelines of the branch.
+func (c *Circle) findPipeline(m *core.Manifest, branch, revision string) (*Pipeline, error) {
+	var found *Pipeline
+	pages := 0
+	query := url.Values{"branch": {branch}}
+	err := c.listV2(fmt.Sprintf("/project/%s/pipeline", c.getProjectSlug(m)), query, func(items json.RawMessage) (bool, error) {
+		pages++
+		var pipelines []Pipeline
+		if err := json.Unmarshal(items, &pipelines); err != nil {
+			return false, err
+		}
+		for i, p := range pipelines {
+			if p.VCS.Revision == revision {
+				found = &pipelines[i]
+				return false, nil
+			}
+		}
+		return pages < maxPipelinePages, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, NoBuildFound
+	}
+	return found, nil
+}
+
+// getWorkflows returns the latest run of each workflow of the pipeline, the reruns replace the previous ones.
+func (c *Circle) getWorkflows(pipeline *Pipeline) ([]Workflow, error) {
+	var workflows []Workflow
+	latest := map[string]int{}
+	err := c.listV2("/pipeline/"+pipeline.ID+"/workflow", nil, func(items json.RawMessage) (bool, error) {
+		var page []Workflow
+		if err := json.Unmarshal(items, &page); err != nil {
+			return false, err
+		}
+		for _, wf := range page {
+			if i, ok := latest[wf.Name]; !ok {
+				latest[wf.Name] = len(workflows)
+				workflows = append(workflows, wf)
+			} else if wf.CreatedAt.After(workflows[i].CreatedAt) {
+				workflows[i] = wf
+			}
+		}
+		return true, nil
+	})
+	return workflows, err
+}
+
+func (c *Circle) getJobs(workflow Workflow) ([]CircleJob, error) {
+	var jobs []CircleJob
+	err := c.listV2("/workflow/"+workflow.ID+"/job", nil, func(items json.RawMessage) (bool, error) {
+		var page []CircleJob
+		err := json.Unmarshal(items, &page)
+		jobs = append(jobs, page...)
+		return true, err
+	})
+	return jobs, err
+}
+
+// sortJobs orders the jobs of the graph so that every job comes after its dependencies.
+func sortJobs(jobs []CircleJob) []CircleJob {
+	byID := map[string]CircleJob{}
+	for _, j := range jobs {
+		byID[j.ID] = j
+	}
+	sorted := append([]CircleJob{}, jobs...)
+	sort.SliceStable(sorted, func(i, k int) bool { return sorted[i].Name < sorted[k].Name })
+	depth := map[string]int{}
+	var getDepth func(id string, seen map[string]bool) int
+	getDepth = func(id string, seen map[string]bool) int {
+		if d, ok := depth[id]; ok {
+			return d
+		}
+		if seen[id] {
+			return 0
+		}
+		seen[id] = true
+		d := 0
+		for _, dep := range byID[id].Dependencies {
+			if dd := getDepth(dep, seen) + 1; dd > d {
+				d = dd
+			}
+		}
+		depth[id] = d
+		return d
+	}
+	for _, j := range sorted {
+		getDepth(j.ID, map[string]bool{})
+	}
+	sort.SliceStable(sorted, func(i, k int) bool { return depth[sorted[i].ID] < depth[sorted[k].ID] })
+	return sorted
+}
+
+func formatJobDuration(j CircleJob) string {
+	var start, stop time.Time
+	if j.StartedAt != nil {
+		start = *j.StartedAt
+	}
+	if j.StoppedAt != nil {
+		stop = *j.StoppedAt
+	}
+	return FormatDuration(start, stop)
+}
+
+func (c *Circle) printPipeline(w io.Writer, pipeline *Pipeline, workflows []Workflow) error {
+	fmt.Fprintf(w, "Pipeline #%d %s %s\n", pipeline.Number, pipeline.VCS.Branch, shortSHA(pipeline.VCS.Revision))
+	for _, wf := range workflows {
+		jobs, err := c.getJobs(wf)
+		if err != nil {
+			return err
+		}
+		names := map[string]string{}
+		for _, j := range jobs {
+			names[j.ID] = j.Name
+		}
+		fmt.Fprintf(w, "\n%s: %s\n", wf.Name, wf.Status)
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, j := range sortJobs(jobs) {
+			var needs []string
+			for _, dep := range j.Dependencies {
+				needs = append(needs, names[dep])
+			}
+			requires := ""
+			if len(needs) > 0 {
+				requires = "after " + strings.Join(needs, ", ")
+			}
+			fmt.Fprintf(table, "  %s\t%s\t%s\t%s\n", j.Name, j.Status, formatJobDuration(j), requires)
+		}
+		if err = table.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForWorkflows polls until no workflow of the pipeline is running, the pipeline can take a
+// few seconds to create them. pending, when set, keeps polling for the workflows which did not
+// start yet, e.g. after a rerun. onPoll, when set, is called with the workflows of each poll.
+func (c *Circle) waitForWorkflows(pipeline *Pipeline, pending func(Workflow) bool, onPoll func([]Workflow)) ([]Workflow, error) {
+	var previous string
+	for {
+		workflows, err := c.getWorkflows(pipeline)
+		if err != nil {
+			return nil, err
+		}
+		if len(workflows) == 0 {
+			if err = c.refreshPipeline(pipeline); err != nil {
+				return nil, err
+			}
+		}
+		if err = checkPipeline(pipeline, workflows, time.Now()); err != nil {
+			return nil, err
+		}
+		if onPoll != nil {
+			onPoll(workflows)
+		}
+		running := len(workflows) == 0
+		var statuses []string
+		for _, wf := range workflows {
+			statuses = append(statuses, wf.Name+": "+wf.Status)
+			running = running || isWorkflowRunning(wf.Status) || (pending != nil && pending(wf))
+		}
+		if !running {
+			return workflows, nil
+		}
+		if status := strings.Join(statuses, ", "); status != previous {
+			log.Printf("Pipeline #%d %s", pipeline.Number, status)
+			previous = status
+		}
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func checkWorkflows(workflows []Workflow) error {
+	var failed []string
+	for _, wf := range workflows {
+		if !isWorkflowSuccess(wf.Status) {
+			failed = append(failed, wf.Name+": "+wf.Status)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("the workflows did not succeed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// TriggerPipeline triggers a pipeline on the branch and waits for all its workflows.
+func (c *Circle) TriggerPipeline(m *core.Manifest, params PipelineParams) error {
+	if params.Branch == "" {
+		params.Branch = m.Branch
+	}
+	slug := c.getProjectSlug(m)
+	body := map[string]interface{}{"branch": params.Branch}
+	endpoint := fmt.Sprintf("/project/%s/pipeline", slug)
+	if isGitHubAppSlug(slug) {
+		// the GitHub App projects can only be triggered through a pipeline definition.
+		endpoint += "/run"
+		body = map[string]interface{}{
+			"config":   map[string]string{"branch": params.Branch},
+			"checkout": map[string]string{"branch": params.Branch},
+		}
+		if m.Circle.Definition != "" {
+			body["definition_id"] = m.Circle.Definition
+		}
+	}
+	if len(params.Parameters) > 0 {
+		body["parameters"] = toPipelineParameters(params.Parameters)
+	}
+	pipeline := &Pipeline{}
+	if err := c.doV2(http.MethodPost, endpoint, nil, body, pipeline); err != nil {
+		if isGitHubAppSlug(slug) && m.Circle.Definition == "" {
+			return fmt.Errorf("%v, set 'circle.definition' in the manifest to the pipeline definition ID of Project Settings > Pipelines", err)
+		}
+		return err
+	}
+	log.Printf("Triggered pipeline #%d on %s: https://app.circleci.com/pipelines/%s/%d",
+		pipeline.Number, params.Branch, slug, pipeline.Number)
+	if params.Async {
+		return nil
+	}
+	return c.waitAndShowPipeline(m, pipeline, nil)
+}
+
+// waitAndShowPipeline streams the output of the failed steps while waiting, then shows the jobs.
+func (c *Circle) waitAndShowPipeline(m *core.Manifest, pipeline *Pipeline, pending func(Workflow) bool) error {
+	workflows, err := c.waitForWorkflows(pipeline, pending, c.newStepsPrinter(os.Stdout, m).printFailedJobs)
+	if err != nil {
+		return err
+	}
+	if err = c.printPipeline(os.Stdout, pipeline, workflows); err != nil {
+		return err
+	}
+	return checkWorkflows(workflows)
+}
+
+// getCommitPipeline returns the given pipeline, or the one of the current commit when 0.
+func (c *Circle) getCommitPipeline(m *core.Manifest, number int64) (*Pipeline, error) {
+	if number != 0 {
+		return c.getPipeline(m, number)
+	}
+	head, err := core.MustInitGit(".").CurrentHEAD()
+	if err != nil {
+		return nil, err
+	}
+	return c.findPipeline(m, m.Branch, head)
+}
+
+// ShowPipeline prints the workflows and the job graph of the pipeline, the one of the current commit when 0.
+func (c *Circle) ShowPipeline(m *core.Manifest, number int64, wait bool) error {
+	pipeline, err := c.getCommitPipeline(m, number)
+	if err != nil {
+		return err
+	}
+	if wait {
+		return c.waitAndShowPipeline(m, pipeline, nil)
+	}
+	workflows, err := c.getWorkflows(pipeline)
+	if err != nil {
+		return err
+	}
+	return c.printPipeline(os.Stdout, pipeline, workflows)
+}
+
+// getPipelineArtifacts returns the artifacts of all the jobs of the pipeline, prefixed by the job name.
+func (c *Circle) getPipelineArtifacts(m *core.Manifest, pipeline *Pipeline) ([]Artifact, error) {
+	workflows, err := c.getWorkflows(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	header.Set("Circle-Token", c.client.Token)
+	var artifacts []Artifact
+	for _, wf := range workflows {
+		jobs, err := c.getJobs(wf)
+		if err != nil {
+			return nil, err
+		}
+		for _, j := range jobs {
+			if j.JobNumber == 0 {
+				// approvals and jobs not started yet.
+				continue
+			}
+			endpoint := fmt.Sprintf("/project/%s/%d/artifacts", c.getProjectSlug(m), j.JobNumber)
+			err = c.listV2(endpoint, nil, func(items json.RawMessage) (bool, error) {
+				var page []struct {
+					Path string `json:"path"`
+					URL  string `json:"url"`
+				}
+				err := json.Unmarshal(items, &page)
+				for _, a := range page {
+					artifacts = append(artifacts, Artifact{Path: j.Name + "/" + a.Path, URL: a.URL, Size: -1, Header: header})
+				}
+				return true, err
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return artifacts, nil
+}
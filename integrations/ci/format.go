@@ -0,0 +1,38 @@
+package ci
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FailedStepLines is the number of lines kept when the output of a failed step is excerpted.
+const FailedStepLines = 100
+
+// FormatDuration rounds to the second, the zero stop time is for the ones still running.
+func FormatDuration(start, stop time.Time) string {
+	if start.IsZero() {
+		return ""
+	}
+	if stop.IsZero() || stop.Before(start) {
+		stop = time.Now()
+	}
+	return (stop.Sub(start) / time.Second * time.Second).String()
+}
+
+func excerpt(text string, lines int) string {
+	split := strings.Split(strings.TrimSpace(text), "\n")
+	if len(split) <= lines {
+		return strings.Join(split, "\n")
+	}
+	return strings.Join(split[:lines], "\n") + fmt.Sprintf("\n... %d more lines", len(split)-lines)
+}
+
+// ExcerptTail keeps the last lines of the text.
+func ExcerptTail(text string, lines int) string {
+	split := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	if len(split) <= lines {
+		return strings.Join(split, "\n")
+	}
+	return fmt.Sprintf("... %d lines before\n", len(split)-lines) + strings.Join(split[len(split)-lines:], "\n")
+}
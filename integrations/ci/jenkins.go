@@ -1,16 +1,19 @@
 package ci
 
 import (
+	"errors"
 	"fmt"
 	"github.com/benchlabs/bub/core"
 	"github.com/benchlabs/bub/utils"
 	"github.com/bndr/gojenkins"
-	"io/ioutil"
+	"github.com/manifoldco/promptui"
 	"log"
+	"net/http"
 	"os"
 	"path"
+	"sort"
 	"strings"
-	"time"
+	"text/tabwriter"
 )
 
 type Jenkins struct {
@@ -23,8 +26,18 @@ func (j *Jenkins) getJobName() string {
 	return path.Join(j.cfg.GitHub.Organization, "job", j.manifest.Repository, "job", j.manifest.Branch)
 }
 
+// WithBranch returns a client for another branch of the multibranch project.
+func (j *Jenkins) WithBranch(branch string) *Jenkins {
+	if branch == "" {
+		return j
+	}
+	m := *j.manifest
+	m.Branch = branch
+	return &Jenkins{cfg: j.cfg, client: j.client, manifest: &m}
+}
+
 func MustInitJenkins(cfg *core.Configuration, m *core.Manifest) *Jenkins {
-	core.CheckServerConfig(cfg.Jenkins.Server)
+	core.CheckServerConfig("Jenkins", cfg.Jenkins.Server)
 	mustLoadJenkinsCredentials(cfg)
 	jenkins := gojenkins.CreateJenkins(cfg.Jenkins.Server, cfg.Jenkins.Username, cfg.Jenkins.Password)
 	client, err := jenkins.Init()
@@ -34,6 +47,18 @@ func MustInitJenkins(cfg *core.Configuration, m *core.Manifest) *Jenkins {
 	return &Jenkins{cfg: cfg, client: client, manifest: m}
 }
 
+// CheckJenkins verifies the stored credentials without prompting.
+func CheckJenkins(cfg *core.Configuration) error {
+	if cfg.Jenkins.Server == "" {
+		return errors.New("server not configured")
+	}
+	if err := core.LookupCredentials("Jenkins", &cfg.Jenkins.Username, &cfg.Jenkins.Password); err != nil {
+		return err
+	}
+	_, err := gojenkins.CreateJenkins(cfg.Jenkins.Server, cfg.Jenkins.Username, cfg.Jenkins.Password).Init()
+	return err
+}
+
 func mustLoadJenkinsCredentials(cfg *core.Configuration) {
 	err := core.LoadCredentials("Jenkins", &cfg.Jenkins.Username, &cfg.Jenkins.Password, cfg.ResetCredentials)
 	if err != nil {
@@ -61,63 +86,67 @@ func (j *Jenkins) getJob() *gojenkins.Job {
 	return job
 }
 
-func (j *Jenkins) getLastBuild() *gojenkins.Build {
-	log.Printf("Fetching last build for '%v' '%v'.", j.manifest.Repository, j.manifest.Branch)
-	lastBuild, err := j.getJob().GetLastBuild()
-	if err != nil {
-		log.Fatalf("Failed to fetch build details. error: %s", err)
+// GetArtifacts downloads the artifacts of the last or the given build, the fingerprints are used as checksums.
+// Only the freestyle jobs export the fingerprints, the pipeline builds are not verified.
+func (j *Jenkins) GetArtifacts(number int64, params ArtifactParams) error {
+	if number == 0 {
+		status, err := j.getJobStatus()
+		if err != nil {
+			return err
+		}
+		if status.LastBuild == nil {
+			return errors.New("no build found, make sure it was triggered at least once")
+		}
+		number = status.LastBuild.Number
 	}
-	log.Printf(lastBuild.GetUrl())
-	return lastBuild
-}
+	var build struct {
+		URL       string `json:"url"`
+		Artifacts []struct {
+			RelativePath string `json:"relativePath"`
+		} `json:"artifacts"`
+		Fingerprint []struct {
+			FileName string `json:"fileName"`
+			Hash     string `json:"hash"`
+		} `json:"fingerprint"`
+	}
+	tree := "url,artifacts[relativePath],fingerprint[fileName,hash]"
+	endpoint := fmt.Sprintf("%s/%d", j.getJobBase(), number)
+	if _, err := j.client.Requester.GetJSON(endpoint, &build, map[string]string{"tree": tree}); err != nil {
+		return fmt.Errorf("failed to fetch build #%d: %v", number, err)
+	}
+	log.Print(build.URL)
 
-func (j *Jenkins) GetArtifacts() error {
-	log.Print("Fetching artifacts.")
-	artifacts := j.getLastBuild().GetArtifacts()
-	dir, err := ioutil.TempDir("", strings.Join([]string{j.manifest.Repository, j.manifest.Branch}, "-"))
-	if err != nil {
-		return nil
+	if len(build.Fingerprint) == 0 && len(build.Artifacts) > 0 {
+		log.Print("The build has no fingerprints, the checksums of the artifacts are not verified.")
 	}
-	for _, artifact := range artifacts {
-		if !strings.Contains(artifact.FileName, ".png") {
-			artifactPath := path.Join(dir, artifact.FileName)
-			log.Println(artifactPath)
-			_, err := artifact.Save(artifactPath)
-			if err != nil {
-				return err
-			}
+	// the fingerprints only have the file name, they are ignored when ambiguous.
+	hashes := map[string]string{}
+	for _, f := range build.Fingerprint {
+		if _, ok := hashes[f.FileName]; ok {
+			hashes[f.FileName] = ""
 		} else {
-			log.Println(j.cfg.Jenkins.Server + artifact.Path)
+			hashes[f.FileName] = f.Hash
 		}
 	}
-	return nil
-}
+	header := http.Header{}
+	req, _ := http.NewRequest(http.MethodGet, build.URL, nil)
+	req.SetBasicAuth(j.cfg.Jenkins.Username, j.cfg.Jenkins.Password)
+	header.Set("Authorization", req.Header.Get("Authorization"))
 
-func (j *Jenkins) ShowConsoleOutput() {
-	var lastChar int
-	for {
-		build, err := j.getJob().GetLastBuild()
-		if lastChar == 0 {
-			log.Print(build.GetUrl())
-		}
-		if err != nil {
-			log.Fatalf("Could not find the last build. make sure it was triggered at least once: %v", err)
+	var artifacts []Artifact
+	for _, a := range build.Artifacts {
+		artifact := Artifact{
+			Path:   a.RelativePath,
+			URL:    build.URL + "artifact/" + a.RelativePath,
+			Size:   -1,
+			Header: header,
 		}
-		consoleOutput := build.GetConsoleOutput()
-		for i, char := range consoleOutput {
-			if i > lastChar {
-				fmt.Print(string(char))
-			}
+		if hash := hashes[path.Base(a.RelativePath)]; hash != "" {
+			artifact.Checksum = "md5:" + hash
 		}
-		lastChar = len(consoleOutput) - 1
-		if !build.IsRunning() {
-			if !build.IsGood() {
-				log.Fatal("The job failed on jenkins.")
-			}
-			break
-		}
-		time.Sleep(2 * time.Second)
+		artifacts = append(artifacts, artifact)
 	}
+	return DownloadArtifacts(artifacts, params)
 }
 
 func (j *Jenkins) OpenPage(p ...string) error {
@@ -125,33 +154,161 @@ func (j *Jenkins) OpenPage(p ...string) error {
 	return utils.OpenURI(append(base, p...)...)
 }
 
-func (j *Jenkins) BuildJob(async bool, force bool) {
+type BuildParams struct {
+	Async, Force bool
+	// Preset is the name of a set of parameters from the manifest or the config.
+	Preset string
+	// Parameters override the ones of the preset.
+	Parameters map[string]string
+}
+
+// ParseBuildParameters parses the KEY=VALUE pairs.
+func ParseBuildParameters(values []string) (map[string]string, error) {
+	params := map[string]string{}
+	for _, v := range values {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid parameter '%s', use KEY=VALUE", v)
+		}
+		params[kv[0]] = kv[1]
+	}
+	return params, nil
+}
+
+func (j *Jenkins) getPreset(name string) (map[string]string, error) {
+	if preset, ok := j.manifest.Jenkins.Presets[name]; ok {
+		return preset, nil
+	}
+	if preset, ok := j.cfg.Jenkins.Presets[name]; ok {
+		return preset, nil
+	}
+	var names []string
+	for n := range j.manifest.Jenkins.Presets {
+		names = append(names, n)
+	}
+	for n := range j.cfg.Jenkins.Presets {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("preset '%s' not found, available: %s", name, strings.Join(names, ", "))
+}
+
+func formatDefaultValue(p gojenkins.ParameterDefinition) string {
+	if p.DefaultParameterValue.Value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", p.DefaultParameterValue.Value)
+}
+
+// ListParameters prints the parameters of the job.
+func (j *Jenkins) ListParameters() error {
+	params, err := j.getJob().GetParameters()
+	if err != nil {
+		return err
+	}
+	if len(params) == 0 {
+		log.Print("The job takes no parameters.")
+		return nil
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "Name\tType\tDefault\tDescription")
+	for _, p := range params {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", p.Name, strings.TrimSuffix(p.Type, "ParameterDefinition"),
+			formatDefaultValue(p), strings.Replace(p.Description, "\n", " ", -1))
+	}
+	return table.Flush()
+}
+
+// resolveParameters checks the parameters against the job definition and prompts for the required ones,
+// the parameters without a default value.
+func (j *Jenkins) resolveParameters(job *gojenkins.Job, params BuildParams) (map[string]string, error) {
+	resolved := map[string]string{}
+	if params.Preset != "" {
+		preset, err := j.getPreset(params.Preset)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range preset {
+			resolved[k] = v
+		}
+	}
+	for k, v := range params.Parameters {
+		resolved[k] = v
+	}
+
+	definitions, err := job.GetParameters()
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]bool{}
+	var names []string
+	for _, d := range definitions {
+		known[d.Name] = true
+		names = append(names, d.Name)
+	}
+	for k := range resolved {
+		if !known[k] {
+			if len(names) == 0 {
+				return nil, fmt.Errorf("unknown parameter '%s', the job takes no parameters", k)
+			}
+			return nil, fmt.Errorf("unknown parameter '%s', available: %s", k, strings.Join(names, ", "))
+		}
+	}
+	for _, d := range definitions {
+		if _, ok := resolved[d.Name]; ok || formatDefaultValue(d) != "" {
+			continue
+		}
+		label := d.Name
+		if d.Description != "" {
+			label = fmt.Sprintf("%s (%s)", d.Name, d.Description)
+		}
+		prompt := promptui.Prompt{Label: label}
+		if d.Type == "PasswordParameterDefinition" {
+			prompt.Mask = '*'
+		}
+		value, err := prompt.Run()
+		if err != nil {
+			return nil, err
+		}
+		resolved[d.Name] = value
+	}
+	return resolved, nil
+}
+
+func (j *Jenkins) BuildJob(params BuildParams) error {
 	jobName := j.getJobName()
 	job := j.getJob()
 	lastBuild, err := job.GetLastBuild()
-	if err == nil && lastBuild.IsRunning() && !force {
+	if err == nil && lastBuild.IsRunning() && !params.Force {
 		log.Fatal("A build for this job is already running pass '--force' to trigger the build.")
 	} else if err != nil && err.Error() != "404" {
 		log.Fatalf("Failed to get last build status: %v", err)
 	}
 
-	job.InvokeSimple(nil)
+	buildParams, err := j.resolveParameters(job, params)
+	if err != nil {
+		log.Fatalf("Invalid build parameters: %v", err)
+	}
+	if len(buildParams) > 0 {
+		var names []string
+		for k := range buildParams {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		log.Printf("Parameters: %s", strings.Join(names, ", "))
+	}
+	queueItem, err := j.invokeBuild(job, buildParams)
+	if err != nil {
+		return fmt.Errorf("failed to trigger the build: %v", err)
+	}
 	log.Printf("Build triggered: %v/job/%v wating for the job to start.", j.cfg.Jenkins.Server, jobName)
 
-	if async {
-		return
+	if params.Async {
+		return nil
 	}
-
-	for {
-		newBuild, err := j.getJob().GetLastBuild()
-		if err == nil && (lastBuild == nil || (lastBuild.GetUrl() != newBuild.GetUrl())) {
-			os.Stderr.WriteString("\n")
-			break
-		} else if err != nil && err.Error() != "404" {
-			log.Fatalf("Failed to get build status: %v", err)
-		}
-		os.Stderr.WriteString(".")
-		time.Sleep(2 * time.Second)
+	number, err := j.waitForQueueItem(queueItem)
+	if err != nil {
+		return err
 	}
-	j.ShowConsoleOutput()
+	return j.ShowConsoleOutput(number)
 }
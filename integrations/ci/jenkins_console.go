@@ -0,0 +1,222 @@
+package ci
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bndr/gojenkins"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// buildStartPolls is the number of polls allowed between the end of the queue and the start of the build.
+const buildStartPolls = 5
+
+// BuildResultError is returned when a followed build did not succeed, the exit code depends on the result.
+type BuildResultError struct {
+	Number int64
+	Result string
+}
+
+func (e BuildResultError) Error() string {
+	message := fmt.Sprintf("Build #%d: %s.", e.Number, e.Result)
+	if e.Result == "FAILURE" || e.Result == "UNSTABLE" {
+		message += " 'bub j tests' lists the failed tests."
+	}
+	return message
+}
+
+// ExitCode is used by the cli, FAILURE is 1, UNSTABLE 2 and ABORTED 3.
+func (e BuildResultError) ExitCode() int {
+	switch e.Result {
+	case "UNSTABLE":
+		return 2
+	case "ABORTED":
+		return 3
+	default:
+		return 1
+	}
+}
+
+type jobStatus struct {
+	InQueue   bool `json:"inQueue"`
+	QueueItem *struct {
+		Why string `json:"why"`
+	} `json:"queueItem"`
+	NextBuildNumber int64 `json:"nextBuildNumber"`
+	LastBuild       *struct {
+		Number int64 `json:"number"`
+	} `json:"lastBuild"`
+}
+
+func (j *Jenkins) getJobStatus() (*jobStatus, error) {
+	status := &jobStatus{}
+	tree := "inQueue,queueItem[why],nextBuildNumber,lastBuild[number]"
+	_, err := j.client.Requester.GetJSON(j.getJobBase(), status, map[string]string{"tree": tree})
+	return status, err
+}
+
+// invokeBuild triggers the build and returns the endpoint of its queue item. Unlike the next build
+// number read beforehand, the queue item cannot be taken by a concurrent trigger.
+func (j *Jenkins) invokeBuild(job *gojenkins.Job, params map[string]string) (string, error) {
+	definitions, err := job.GetParameters()
+	if err != nil {
+		return "", err
+	}
+	endpoint := job.Base + "/build"
+	if len(definitions) > 0 {
+		endpoint = job.Base + "/buildWithParameters"
+	}
+	data := url.Values{}
+	for k, v := range params {
+		data.Set(k, v)
+	}
+	var body string
+	resp, err := j.client.Requester.Post(endpoint, strings.NewReader(data.Encode()), &body, nil)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return "", errors.New(resp.Status)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		return "", errors.New("no queue item returned for the build")
+	}
+	server, err := url.Parse(j.cfg.Jenkins.Server)
+	if err != nil {
+		return "", err
+	}
+	// the endpoints are relative to the server, which can have a path.
+	return strings.TrimPrefix(location.Path, strings.TrimSuffix(server.Path, "/")), nil
+}
+
+// waitForQueueItem waits until the queued build starts and returns its number.
+func (j *Jenkins) waitForQueueItem(queueItem string) (int64, error) {
+	var why string
+	for {
+		var item struct {
+			Why        string `json:"why"`
+			Cancelled  bool   `json:"cancelled"`
+			Executable *struct {
+				Number int64 `json:"number"`
+			} `json:"executable"`
+		}
+		resp, err := j.client.Requester.GetJSON(queueItem, &item, nil)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return 0, fmt.Errorf("failed to fetch the queue item %s: %s", queueItem, resp.Status)
+		}
+		switch {
+		case item.Cancelled:
+			return 0, errors.New("the build was cancelled while in the queue")
+		case item.Executable != nil:
+			return item.Executable.Number, nil
+		}
+		if item.Why != "" && item.Why != why {
+			why = item.Why
+			log.Printf("Waiting in the queue: %s", why)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// waitForBuild resolves the build to follow, the queued or the last one when 0, and waits while it is in the queue.
+func (j *Jenkins) waitForBuild(number int64) (int64, error) {
+	var why string
+	missed := 0
+	for {
+		status, err := j.getJobStatus()
+		if err != nil {
+			return 0, err
+		}
+		if number == 0 {
+			if status.InQueue {
+				number = status.NextBuildNumber
+			} else if status.LastBuild != nil {
+				return status.LastBuild.Number, nil
+			} else {
+				return 0, errors.New("no build found, make sure it was triggered at least once")
+			}
+		}
+		if status.LastBuild != nil && status.LastBuild.Number >= number {
+			return number, nil
+		}
+		if !status.InQueue {
+			// the build can take a moment to show up once it leaves the queue.
+			missed++
+			if missed >= buildStartPolls {
+				return 0, fmt.Errorf("build #%d does not exist and nothing is queued", number)
+			}
+		}
+		if status.QueueItem != nil && status.QueueItem.Why != why {
+			why = status.QueueItem.Why
+			log.Printf("Waiting in the queue: %s", why)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// streamConsole prints the console as it grows, only the new bytes are downloaded.
+func (j *Jenkins) streamConsole(w io.Writer, number int64) error {
+	endpoint := fmt.Sprintf("%s/%d/logText/progressiveText", j.getJobBase(), number)
+	var offset int64
+	for {
+		var content string
+		resp, err := j.client.Requester.GetXML(endpoint, &content, map[string]string{"start": strconv.FormatInt(offset, 10)})
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to fetch the console of build #%d: %s", number, resp.Status)
+		}
+		if _, err = io.WriteString(w, content); err != nil {
+			return err
+		}
+		// without the size the whole console would be printed again.
+		size, err := strconv.ParseInt(resp.Header.Get("X-Text-Size"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid X-Text-Size '%s' for the console of build #%d", resp.Header.Get("X-Text-Size"), number)
+		}
+		offset = size
+		if resp.Header.Get("X-More-Data") != "true" {
+			return nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// ShowConsoleOutput streams the console of the build, the queued or the last one when 0,
+// and returns a BuildResultError if it did not succeed.
+func (j *Jenkins) ShowConsoleOutput(number int64) error {
+	number, err := j.waitForBuild(number)
+	if err != nil {
+		return err
+	}
+	build, err := j.getBuildDetails(number)
+	if err != nil {
+		return fmt.Errorf("could not find build #%d: %v", number, err)
+	}
+	log.Print(build.URL)
+	if err = j.streamConsole(os.Stdout, number); err != nil {
+		return err
+	}
+	// the result is set shortly after the end of the console.
+	for build.Building || build.Result == "" {
+		time.Sleep(time.Second)
+		if build, err = j.getBuildDetails(number); err != nil {
+			return err
+		}
+	}
+	if build.Result != "SUCCESS" {
+		return BuildResultError{Number: number, Result: build.Result}
+	}
+	return nil
+}
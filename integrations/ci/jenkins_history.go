@@ -0,0 +1,220 @@
+package ci
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const buildTree = "number,result,building,duration,timestamp,url," +
+	"actions[causes[shortDescription],lastBuiltRevision[SHA1]]," +
+	"changeSet[items[commitId,msg,author[fullName]]],changeSets[items[commitId,msg,author[fullName]]]"
+
+type jenkinsChangeSet struct {
+	Items []struct {
+		CommitID string `json:"commitId"`
+		Msg      string `json:"msg"`
+		Author   struct {
+			FullName string `json:"fullName"`
+		} `json:"author"`
+	} `json:"items"`
+}
+
+type jenkinsBuild struct {
+	Number    int64  `json:"number"`
+	Result    string `json:"result"`
+	Building  bool   `json:"building"`
+	Duration  int64  `json:"duration"`
+	Timestamp int64  `json:"timestamp"`
+	URL       string `json:"url"`
+	Actions   []struct {
+		Causes []struct {
+			ShortDescription string `json:"shortDescription"`
+		} `json:"causes"`
+		LastBuiltRevision *struct {
+			SHA1 string `json:"SHA1"`
+		} `json:"lastBuiltRevision"`
+	} `json:"actions"`
+	// freestyle jobs have a single change set, pipelines one per checkout.
+	ChangeSet  jenkinsChangeSet   `json:"changeSet"`
+	ChangeSets []jenkinsChangeSet `json:"changeSets"`
+}
+
+func (b jenkinsBuild) getResult() string {
+	if b.Building {
+		return "RUNNING"
+	}
+	return b.Result
+}
+
+func (b jenkinsBuild) getDuration() time.Duration {
+	if b.Building {
+		return time.Since(b.getStart()) / time.Second * time.Second
+	}
+	return time.Duration(b.Duration/1000) * time.Second
+}
+
+func (b jenkinsBuild) getStart() time.Time {
+	return time.Unix(0, b.Timestamp*int64(time.Millisecond))
+}
+
+func (b jenkinsBuild) getCause() string {
+	var causes []string
+	for _, a := range b.Actions {
+		for _, c := range a.Causes {
+			causes = append(causes, c.ShortDescription)
+		}
+	}
+	return strings.Join(causes, ", ")
+}
+
+func (b jenkinsBuild) getSHA() string {
+	for _, a := range b.Actions {
+		if a.LastBuiltRevision != nil {
+			return a.LastBuiltRevision.SHA1
+		}
+	}
+	return ""
+}
+
+func (b jenkinsBuild) getChangeSets() []jenkinsChangeSet {
+	return append([]jenkinsChangeSet{b.ChangeSet}, b.ChangeSets...)
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 8 {
+		return sha[:8]
+	}
+	return sha
+}
+
+func (j *Jenkins) getJobBase() string {
+	return "/job/" + j.getJobName()
+}
+
+func (j *Jenkins) getBuildDetails(number int64) (*jenkinsBuild, error) {
+	build := &jenkinsBuild{}
+	_, err := j.client.Requester.GetJSON(fmt.Sprintf("%s/%d", j.getJobBase(), number), build, map[string]string{"tree": buildTree})
+	return build, err
+}
+
+// JobNotFoundError is returned for the branches Jenkins has not indexed yet, e.g. right after their first push.
+type JobNotFoundError struct {
+	Job string
+}
+
+func (e *JobNotFoundError) Error() string {
+	return fmt.Sprintf("the job %s does not exist (yet)", e.Job)
+}
+
+func (j *Jenkins) getBuilds(limit int) ([]jenkinsBuild, error) {
+	var job struct {
+		Builds []jenkinsBuild `json:"builds"`
+	}
+	tree := fmt.Sprintf("builds[%s]{0,%d}", buildTree, limit)
+	resp, err := j.client.Requester.GetJSON(j.getJobBase(), &job, map[string]string{"tree": tree})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, &JobNotFoundError{j.getJobName()}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the builds of %s: %v", j.getJobName(), err)
+	}
+	if resp != nil && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch the builds of %s: %s", j.getJobName(), resp.Status)
+	}
+	return job.Builds, nil
+}
+
+// ShowHistory lists the recent builds of the branch.
+func (j *Jenkins) ShowHistory(limit int) error {
+	builds, err := j.getBuilds(limit)
+	if err != nil {
+		return err
+	}
+	log.Printf("Builds of '%v' '%v'.", j.manifest.Repository, j.manifest.Branch)
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "Build\tResult\tDuration\tStarted\tCommit\tCause")
+	for _, b := range builds {
+		fmt.Fprintf(table, "#%d\t%s\t%s\t%s\t%s\t%s\n", b.Number, b.getResult(), b.getDuration(),
+			b.getStart().Format("2006-01-02 15:04"), shortSHA(b.getSHA()), b.getCause())
+	}
+	return table.Flush()
+}
+
+// DiffBuilds lists the commits built after the first build, up to the second.
+func (j *Jenkins) DiffBuilds(from, to int64) error {
+	if from >= to {
+		return fmt.Errorf("build #%d must be before #%d", from, to)
+	}
+	first, err := j.getBuildDetails(from)
+	if err != nil {
+		return fmt.Errorf("failed to fetch build #%d: %v", from, err)
+	}
+	var last *jenkinsBuild
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for n := from + 1; n <= to; n++ {
+		build, err := j.getBuildDetails(n)
+		if err != nil {
+			// builds can be discarded.
+			log.Printf("Skipping build #%d: %v", n, err)
+			continue
+		}
+		last = build
+		for _, changeSet := range build.getChangeSets() {
+			for _, item := range changeSet.Items {
+				message := strings.SplitN(item.Msg, "\n", 2)[0]
+				fmt.Fprintf(table, "#%d\t%s\t%s\t%s\n", n, shortSHA(item.CommitID), item.Author.FullName, message)
+			}
+		}
+	}
+	if err = table.Flush(); err != nil {
+		return err
+	}
+	if last != nil && first.getSHA() != "" && last.getSHA() != "" {
+		log.Printf("git log %s..%s", shortSHA(first.getSHA()), shortSHA(last.getSHA()))
+	}
+	return nil
+}
+
+// ShowFailingBranches lists the branches of the multibranch project whose last build did not succeed.
+func (j *Jenkins) ShowFailingBranches() error {
+	var project struct {
+		Jobs []struct {
+			Name      string        `json:"name"`
+			LastBuild *jenkinsBuild `json:"lastBuild"`
+		} `json:"jobs"`
+	}
+	base := "/job/" + path.Join(j.cfg.GitHub.Organization, "job", j.manifest.Repository)
+	tree := "jobs[name,lastBuild[number,result,timestamp,url]]"
+	if _, err := j.client.Requester.GetJSON(base, &project, map[string]string{"tree": tree}); err != nil {
+		return fmt.Errorf("failed to fetch the branches of %s: %v", j.manifest.Repository, err)
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "Branch\tBuild\tResult\tStarted\tURL")
+	failing := 0
+	for _, job := range project.Jobs {
+		b := job.LastBuild
+		if b == nil || b.Result == "" || b.Result == "SUCCESS" || b.Result == "ABORTED" {
+			continue
+		}
+		failing++
+		// the branch names are escaped, e.g. feature%2Fsomething.
+		name, err := url.PathUnescape(job.Name)
+		if err != nil {
+			name = job.Name
+		}
+		fmt.Fprintf(table, "%s\t#%d\t%s\t%s\t%s\n", name, b.Number, b.Result,
+			b.getStart().Format("2006-01-02 15:04"), b.URL)
+	}
+	if failing == 0 {
+		log.Printf("All the branches of %s are passing.", j.manifest.Repository)
+		return nil
+	}
+	return table.Flush()
+}
@@ -0,0 +1,261 @@
+package ci
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/bndr/gojenkins"
+)
+
+// stackTraceLines is the length of the stack trace excerpt of the text report.
+const stackTraceLines = 10
+
+type TestReportParams struct {
+	// Build is the build number, the last build when 0.
+	Build int64
+	// Format is text, json or junit.
+	Format string
+}
+
+type testReport struct {
+	Duration  float64     `json:"duration"`
+	FailCount int         `json:"failCount"`
+	PassCount int         `json:"passCount"`
+	SkipCount int         `json:"skipCount"`
+	Suites    []testSuite `json:"suites"`
+}
+
+type testSuite struct {
+	Name     string     `json:"name"`
+	Duration float64    `json:"duration"`
+	Cases    []testCase `json:"cases"`
+}
+
+type testCase struct {
+	ClassName       string  `json:"className"`
+	Name            string  `json:"name"`
+	Duration        float64 `json:"duration"`
+	Status          string  `json:"status"`
+	Skipped         bool    `json:"skipped"`
+	ErrorDetails    string  `json:"errorDetails"`
+	ErrorStackTrace string  `json:"errorStackTrace"`
+}
+
+func (c testCase) failed() bool {
+	return c.Status == "FAILED" || c.Status == "REGRESSION"
+}
+
+func (c testCase) id() string {
+	return c.ClassName + "." + c.Name
+}
+
+type TestFailure struct {
+	Suite      string `json:"suite"`
+	ClassName  string `json:"className"`
+	Name       string `json:"name"`
+	Message    string `json:"message"`
+	StackTrace string `json:"stackTrace"`
+	// New is false when the test also failed in the previous build.
+	New bool `json:"new"`
+}
+
+type TestSummary struct {
+	Build     int64         `json:"build"`
+	URL       string        `json:"url"`
+	Result    string        `json:"result"`
+	Duration  float64       `json:"duration"`
+	Passed    int           `json:"passed"`
+	Failed    int           `json:"failed"`
+	Skipped   int           `json:"skipped"`
+	Failures  []TestFailure `json:"failures"`
+	Recurring int           `json:"recurring"`
+}
+
+func (j *Jenkins) getTestReport(build *gojenkins.Build) (*testReport, error) {
+	report := &testReport{}
+	_, err := j.client.Requester.GetJSON(build.Base+"/testReport", report, nil)
+	if err != nil {
+		return nil, fmt.Errorf("no test report for build #%d: %v", build.GetBuildNumber(), err)
+	}
+	return report, nil
+}
+
+func (j *Jenkins) getBuild(number int64) (*gojenkins.Build, error) {
+	job := j.getJob()
+	if number == 0 {
+		return job.GetLastBuild()
+	}
+	return job.GetBuild(number)
+}
+
+// getPreviousFailures returns the ids of the tests failing in the build before, empty if it has no report.
+func (j *Jenkins) getPreviousFailures(build *gojenkins.Build) map[string]bool {
+	failures := map[string]bool{}
+	if build.GetBuildNumber() <= 1 {
+		return failures
+	}
+	previous, err := j.getJob().GetBuild(build.GetBuildNumber() - 1)
+	if err != nil {
+		return failures
+	}
+	report, err := j.getTestReport(previous)
+	if err != nil {
+		return failures
+	}
+	for _, s := range report.Suites {
+		for _, c := range s.Cases {
+			if c.failed() {
+				failures[c.id()] = true
+			}
+		}
+	}
+	return failures
+}
+
+func summarizeTestReport(report *testReport, previousFailures map[string]bool) TestSummary {
+	summary := TestSummary{
+		Duration: report.Duration,
+		Passed:   report.PassCount,
+		Failed:   report.FailCount,
+		Skipped:  report.SkipCount,
+	}
+	for _, s := range report.Suites {
+		for _, c := range s.Cases {
+			if !c.failed() {
+				continue
+			}
+			failure := TestFailure{
+				Suite:      s.Name,
+				ClassName:  c.ClassName,
+				Name:       c.Name,
+				Message:    c.ErrorDetails,
+				StackTrace: c.ErrorStackTrace,
+				New:        !previousFailures[c.id()],
+			}
+			if !failure.New {
+				summary.Recurring++
+			}
+			summary.Failures = append(summary.Failures, failure)
+		}
+	}
+	return summary
+}
+
+func indent(text string) string {
+	return "    " + strings.Replace(text, "\n", "\n    ", -1)
+}
+
+func printTestSummary(w io.Writer, summary TestSummary) {
+	fmt.Fprintf(w, "Build #%d %s: %d passed, %d failed (%d new, %d recurring), %d skipped in %.0fs.\n",
+		summary.Build, summary.Result, summary.Passed, summary.Failed, summary.Failed-summary.Recurring,
+		summary.Recurring, summary.Skipped, summary.Duration)
+	for _, f := range summary.Failures {
+		status := "NEW"
+		if !f.New {
+			status = "RECURRING"
+		}
+		fmt.Fprintf(w, "\n[%s] %s.%s\n", status, f.ClassName, f.Name)
+		if f.Message != "" {
+			fmt.Fprintln(w, excerpt(f.Message, stackTraceLines))
+		}
+		if f.StackTrace != "" {
+			fmt.Fprintln(w, indent(excerpt(f.StackTrace, stackTraceLines)))
+		}
+	}
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     float64         `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message    string `xml:"message,attr"`
+	StackTrace string `xml:",chardata"`
+}
+
+func writeJUnitReport(w io.Writer, report *testReport) error {
+	suites := junitTestSuites{}
+	for _, s := range report.Suites {
+		suite := junitTestSuite{Name: s.Name, Time: s.Duration, Tests: len(s.Cases)}
+		for _, c := range s.Cases {
+			testCase := junitTestCase{ClassName: c.ClassName, Name: c.Name, Time: c.Duration}
+			if c.failed() {
+				suite.Failures++
+				testCase.Failure = &junitFailure{Message: c.ErrorDetails, StackTrace: c.ErrorStackTrace}
+			} else if c.Skipped {
+				suite.Skipped++
+				testCase.Skipped = &struct{}{}
+			}
+			suite.Cases = append(suite.Cases, testCase)
+		}
+		suites.Suites = append(suites.Suites, suite)
+	}
+	data, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, data)
+	return err
+}
+
+// ShowTestReport prints the failed tests of the build, flagged as new or recurring compared to the previous build.
+func (j *Jenkins) ShowTestReport(params TestReportParams) error {
+	switch params.Format {
+	case "text", "json", "junit":
+	default:
+		return fmt.Errorf("unknown format '%s', use text, json or junit", params.Format)
+	}
+	build, err := j.getBuild(params.Build)
+	if err != nil {
+		return fmt.Errorf("could not find the build: %v", err)
+	}
+	if build.IsRunning() {
+		log.Printf("Build #%d is still running, the report may be incomplete.", build.GetBuildNumber())
+	}
+	report, err := j.getTestReport(build)
+	if err != nil {
+		return err
+	}
+	if params.Format == "junit" {
+		return writeJUnitReport(os.Stdout, report)
+	}
+
+	summary := summarizeTestReport(report, j.getPreviousFailures(build))
+	summary.Build = build.GetBuildNumber()
+	summary.URL = build.GetUrl()
+	summary.Result = build.GetResult()
+	if params.Format == "json" {
+		data, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Println(string(data))
+		return err
+	}
+	log.Print(summary.URL)
+	printTestSummary(os.Stdout, summary)
+	return nil
+}
@@ -15,3 +15,34 @@ func TestGetJobName(t *testing.T) {
 	j := Jenkins{cfg: &cfg, manifest: &manifest}
 	assert.Equal(t, "BenchLabs/job/test/job/master", j.getJobName())
 }
+
+func TestParseBuildParameters(t *testing.T) {
+	t.Parallel()
+	params, err := ParseBuildParameters([]string{"SUITE=smoke", "URL=https://a.com/?b=c", "EMPTY="})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"SUITE": "smoke", "URL": "https://a.com/?b=c", "EMPTY": ""}, params)
+
+	_, err = ParseBuildParameters([]string{"SUITE"})
+	assert.NotNil(t, err)
+}
+
+func TestSummarizeTestReport(t *testing.T) {
+	t.Parallel()
+	report := &testReport{FailCount: 2, PassCount: 1, Suites: []testSuite{{Name: "suite", Cases: []testCase{
+		{ClassName: "a.B", Name: "passes", Status: "PASSED"},
+		{ClassName: "a.B", Name: "breaks", Status: "REGRESSION"},
+		{ClassName: "a.B", Name: "flaky", Status: "FAILED"},
+	}}}}
+	summary := summarizeTestReport(report, map[string]bool{"a.B.flaky": true})
+	assert.Equal(t, 2, len(summary.Failures))
+	assert.True(t, summary.Failures[0].New)
+	assert.False(t, summary.Failures[1].New)
+	assert.Equal(t, 1, summary.Recurring)
+}
+
+func TestBuildResultExitCode(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, 1, BuildResultError{Result: "FAILURE"}.ExitCode())
+	assert.Equal(t, 2, BuildResultError{Result: "UNSTABLE"}.ExitCode())
+	assert.Equal(t, 3, BuildResultError{Result: "ABORTED"}.ExitCode())
+}
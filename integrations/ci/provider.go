@@ -0,0 +1,355 @@
+package ci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/benchlabs/bub/core"
+	"github.com/benchlabs/bub/utils"
+)
+
+const (
+	StateRunning = "running"
+	StateSuccess = "success"
+	StateFailed  = "failed"
+	// StateOnHold is a CircleCI workflow waiting for an approval.
+	StateOnHold = "on_hold"
+)
+
+// ErrNoBuildForCommit is returned until the CI picks up the commit.
+var ErrNoBuildForCommit = errors.New("no build found for the commit yet")
+
+// pollInterval is the delay between the status checks of WaitForCommit.
+var pollInterval = 10 * time.Second
+
+type BuildStatus struct {
+	Provider string `json:"provider"`
+	Commit   string `json:"commit"`
+	State    string `json:"state"`
+	URL      string `json:"url"`
+}
+
+// Provider is implemented by the CI backends, the builds are found by the commit of the current branch.
+type Provider interface {
+	Name() string
+	Trigger(params map[string]string) error
+	// Status returns ErrNoBuildForCommit until the build of the commit starts.
+	Status(commit string) (*BuildStatus, error)
+	// Wait polls until the build of the commit is no longer running.
+	Wait(commit string, timeout time.Duration) (*BuildStatus, error)
+	Logs(commit string) error
+	Artifacts(commit string, params ArtifactParams) error
+	Tests(commit string) error
+}
+
+// providerFiles are the configuration files of each CI provider.
+var providerFiles = []struct{ name, path string }{
+	{"jenkins", "Jenkinsfile"},
+	{"circle", ".circleci"},
+	{"circle", "circle.yml"},
+}
+
+// githubWorkflows is only used when no other CI is configured, the repositories often have
+// workflows besides their CI, e.g. CodeQL or Dependabot.
+const githubWorkflows = ".github/workflows"
+
+// DetectProvider uses the 'ci' of the manifest, or the CI configuration files of the repository.
+func DetectProvider(m *core.Manifest) (string, error) {
+	if m.CI != "" {
+		return m.CI, nil
+	}
+	var found []string
+	for _, f := range providerFiles {
+		exists, err := utils.PathExists(f.path)
+		if err != nil {
+			return "", err
+		}
+		if exists && (len(found) == 0 || found[len(found)-1] != f.name) {
+			found = append(found, f.name)
+		}
+	}
+	if len(found) == 0 {
+		exists, err := utils.PathExists(githubWorkflows)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			found = append(found, "github")
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", errors.New("no CI configuration found, set 'ci' in the manifest to jenkins, circle or github")
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("several CI configurations found (%s), set 'ci' in the manifest", strings.Join(found, ", "))
+	}
+}
+
+// WaitForCommit polls the status until it is no longer running, the build may not exist yet.
+func WaitForCommit(commit string, timeout time.Duration, getStatus func() (*BuildStatus, error)) (*BuildStatus, error) {
+	start := time.Now()
+	var previous string
+	for {
+		status, err := getStatus()
+		if err != nil && err != ErrNoBuildForCommit {
+			return nil, err
+		}
+		if err == nil && status.State != StateRunning {
+			return status, nil
+		}
+		if timeout > 0 && time.Since(start) > timeout {
+			return nil, fmt.Errorf("timed out after %v waiting for the build of %s", timeout, shortSHA(commit))
+		}
+		state := "waiting for the build to start"
+		if status != nil {
+			state = status.State + " " + status.URL
+		}
+		if state != previous {
+			log.Printf("%s: %s", shortSHA(commit), state)
+			previous = state
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+func getJenkinsState(b *jenkinsBuild) string {
+	switch {
+	case b.Building:
+		return StateRunning
+	case b.Result == "SUCCESS":
+		return StateSuccess
+	default:
+		return StateFailed
+	}
+}
+
+func (j *Jenkins) Name() string {
+	return "jenkins"
+}
+
+func (j *Jenkins) findBuild(commit string) (*jenkinsBuild, error) {
+	builds, err := j.getBuilds(20)
+	if _, ok := err.(*JobNotFoundError); ok {
+		return nil, ErrNoBuildForCommit
+	} else if err != nil {
+		return nil, err
+	}
+	for i, b := range builds {
+		if b.getSHA() == commit {
+			return &builds[i], nil
+		}
+	}
+	return nil, ErrNoBuildForCommit
+}
+
+func (j *Jenkins) Trigger(params map[string]string) error {
+	return j.BuildJob(BuildParams{Parameters: params, Async: true})
+}
+
+func (j *Jenkins) Status(commit string) (*BuildStatus, error) {
+	b, err := j.findBuild(commit)
+	if err != nil {
+		return nil, err
+	}
+	return &BuildStatus{Provider: j.Name(), Commit: commit, State: getJenkinsState(b), URL: b.URL}, nil
+}
+
+func (j *Jenkins) Wait(commit string, timeout time.Duration) (*BuildStatus, error) {
+	return WaitForCommit(commit, timeout, func() (*BuildStatus, error) { return j.Status(commit) })
+}
+
+func (j *Jenkins) Logs(commit string) error {
+	b, err := j.findBuild(commit)
+	if err != nil {
+		return err
+	}
+	return j.ShowConsoleOutput(b.Number)
+}
+
+func (j *Jenkins) Artifacts(commit string, params ArtifactParams) error {
+	b, err := j.findBuild(commit)
+	if err != nil {
+		return err
+	}
+	return j.GetArtifacts(b.Number, params)
+}
+
+func (j *Jenkins) Tests(commit string) error {
+	b, err := j.findBuild(commit)
+	if err != nil {
+		return err
+	}
+	return j.ShowTestReport(TestReportParams{Build: b.Number, Format: "text"})
+}
+
+// circleProvider binds the Circle client to the manifest of the repository.
+type circleProvider struct {
+	*Circle
+	manifest *core.Manifest
+}
+
+func NewCircleProvider(cfg *core.Configuration, m *core.Manifest) Provider {
+	return &circleProvider{Circle: MustInitCircle(cfg), manifest: m}
+}
+
+func (c *circleProvider) Name() string {
+	return "circle"
+}
+
+func (c *circleProvider) findPipeline(commit string) (*Pipeline, error) {
+	pipeline, err := c.Circle.findPipeline(c.manifest, c.manifest.Branch, commit)
+	if err == NoBuildFound {
+		return nil, ErrNoBuildForCommit
+	}
+	return pipeline, err
+}
+
+func getWorkflowsState(workflows []Workflow) string {
+	if len(workflows) == 0 {
+		return StateRunning
+	}
+	state := StateSuccess
+	for _, wf := range workflows {
+		switch {
+		case isWorkflowRunning(wf.Status):
+			return StateRunning
+		case wf.Status == "on_hold" && state != StateFailed:
+			state = StateOnHold
+		case !isWorkflowSuccess(wf.Status) && wf.Status != "on_hold":
+			state = StateFailed
+		}
+	}
+	return state
+}
+
+func (c *circleProvider) Trigger(params map[string]string) error {
+	return c.TriggerPipeline(c.manifest, PipelineParams{Parameters: params, Async: true})
+}
+
+func (c *circleProvider) Status(commit string) (*BuildStatus, error) {
+	pipeline, err := c.findPipeline(commit)
+	if err != nil {
+		return nil, err
+	}
+	workflows, err := c.getWorkflows(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	state := getWorkflowsState(workflows)
+	if len(workflows) == 0 {
+		if err = c.refreshPipeline(pipeline); err != nil {
+			return nil, err
+		}
+	}
+	if err = checkPipeline(pipeline, workflows, time.Now()); err != nil {
+		log.Print(err)
+		state = StateFailed
+	}
+	return &BuildStatus{
+		Provider: c.Name(),
+		Commit:   commit,
+		State:    state,
+		URL:      fmt.Sprintf("https://app.circleci.com/pipelines/%s/%d", c.getProjectSlug(c.manifest), pipeline.Number),
+	}, nil
+}
+
+func (c *circleProvider) Wait(commit string, timeout time.Duration) (*BuildStatus, error) {
+	return WaitForCommit(commit, timeout, func() (*BuildStatus, error) { return c.Status(commit) })
+}
+
+func (c *circleProvider) Logs(commit string) error {
+	pipeline, err := c.findPipeline(commit)
+	if err != nil {
+		return err
+	}
+	workflows, err := c.getWorkflows(pipeline)
+	if err != nil {
+		return err
+	}
+	if err = c.printPipeline(os.Stdout, pipeline, workflows); err != nil {
+		return err
+	}
+	c.newStepsPrinter(os.Stdout, c.manifest).printFailedJobs(workflows)
+	return nil
+}
+
+func (c *circleProvider) Artifacts(commit string, params ArtifactParams) error {
+	pipeline, err := c.findPipeline(commit)
+	if err != nil {
+		return err
+	}
+	artifacts, err := c.getPipelineArtifacts(c.manifest, pipeline)
+	if err != nil {
+		return err
+	}
+	return DownloadArtifacts(artifacts, params)
+}
+
+// Tests prints the failed tests of the jobs of the pipeline, from the stored test metadata.
+func (c *circleProvider) Tests(commit string) error {
+	pipeline, err := c.findPipeline(commit)
+	if err != nil {
+		return err
+	}
+	workflows, err := c.getWorkflows(pipeline)
+	if err != nil {
+		return err
+	}
+	failures := 0
+	for _, wf := range workflows {
+		jobs, err := c.getJobs(wf)
+		if err != nil {
+			return err
+		}
+		for _, j := range jobs {
+			if j.JobNumber == 0 {
+				continue
+			}
+			endpoint := fmt.Sprintf("/project/%s/%d/tests", c.getProjectSlug(c.manifest), j.JobNumber)
+			err = c.listV2(endpoint, nil, func(items json.RawMessage) (bool, error) {
+				var tests []struct {
+					Name      string `json:"name"`
+					ClassName string `json:"classname"`
+					Result    string `json:"result"`
+					Message   string `json:"message"`
+				}
+				if err := json.Unmarshal(items, &tests); err != nil {
+					return false, err
+				}
+				for _, t := range tests {
+					if t.Result != "failure" && t.Result != "error" {
+						continue
+					}
+					failures++
+					fmt.Printf("\n[%s] %s %s.%s\n%s\n", j.Name, t.Result, t.ClassName, t.Name, excerpt(t.Message, stackTraceLines))
+				}
+				return true, nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	log.Printf("%d failed tests.", failures)
+	return nil
+}
+
+func PrintBuildStatus(status *BuildStatus, asJSON bool) error {
+	if asJSON {
+		data, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	fmt.Printf("%s %s: %s %s\n", status.Provider, shortSHA(status.Commit), status.State, status.URL)
+	return nil
+}
@@ -0,0 +1,90 @@
+package ci
+
+import (
+	"errors"
+	"github.com/benchlabs/bub/core"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDetectProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bub-ci")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	defer os.Chdir(wd)
+	assert.NoError(t, os.Chdir(dir))
+
+	_, err = DetectProvider(&core.Manifest{})
+	assert.Error(t, err)
+
+	name, err := DetectProvider(&core.Manifest{CI: "circle"})
+	assert.NoError(t, err)
+	assert.Equal(t, "circle", name)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "Jenkinsfile"), nil, 0644))
+	name, err = DetectProvider(&core.Manifest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "jenkins", name)
+
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, ".circleci"), 0755))
+	_, err = DetectProvider(&core.Manifest{})
+	assert.Error(t, err)
+
+	assert.NoError(t, os.Remove(filepath.Join(dir, "Jenkinsfile")))
+	name, err = DetectProvider(&core.Manifest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "circle", name)
+
+	// the GitHub workflows are not the CI when another one is configured.
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, ".github", "workflows"), 0755))
+	name, err = DetectProvider(&core.Manifest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "circle", name)
+
+	assert.NoError(t, os.RemoveAll(filepath.Join(dir, ".circleci")))
+	name, err = DetectProvider(&core.Manifest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "github", name)
+}
+
+func TestGetWorkflowsState(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, StateRunning, getWorkflowsState(nil))
+	assert.Equal(t, StateSuccess, getWorkflowsState([]Workflow{{Status: "success"}, {Status: "success"}}))
+	assert.Equal(t, StateRunning, getWorkflowsState([]Workflow{{Status: "failed"}, {Status: "running"}}))
+	assert.Equal(t, StateFailed, getWorkflowsState([]Workflow{{Status: "success"}, {Status: "failed"}}))
+	assert.Equal(t, StateFailed, getWorkflowsState([]Workflow{{Status: "failed"}, {Status: "on_hold"}}))
+	assert.Equal(t, StateFailed, getWorkflowsState([]Workflow{{Status: "on_hold"}, {Status: "failed"}}))
+	assert.Equal(t, StateOnHold, getWorkflowsState([]Workflow{{Status: "success"}, {Status: "on_hold"}}))
+}
+
+func TestWaitForCommit(t *testing.T) {
+	pollInterval = time.Millisecond
+	defer func() { pollInterval = 10 * time.Second }()
+
+	var calls int
+	statuses := []string{"", StateRunning, StateSuccess}
+	status, err := WaitForCommit("abc", 0, func() (*BuildStatus, error) {
+		state := statuses[calls]
+		calls++
+		if state == "" {
+			return nil, ErrNoBuildForCommit
+		}
+		return &BuildStatus{State: state}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, StateSuccess, status.State)
+	assert.Equal(t, 3, calls)
+
+	_, err = WaitForCommit("abc", 0, func() (*BuildStatus, error) { return nil, errors.New("unauthorized") })
+	assert.EqualError(t, err, "unauthorized")
+
+	_, err = WaitForCommit("abc", 5*time.Millisecond, func() (*BuildStatus, error) { return nil, ErrNoBuildForCommit })
+	assert.Error(t, err)
+}
@@ -0,0 +1,447 @@
+package github
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/benchlabs/bub/core"
+	"github.com/benchlabs/bub/integrations/ci"
+	"github.com/benchlabs/bub/utils"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const githubAPI = "https://api.github.com"
+
+type WorkflowRun struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	HeadBranch string    `json:"head_branch"`
+	HeadSHA    string    `json:"head_sha"`
+	Event      string    `json:"event"`
+	Status     string    `json:"status"`
+	Conclusion string    `json:"conclusion"`
+	RunNumber  int       `json:"run_number"`
+	RunAttempt int       `json:"run_attempt"`
+	HTMLURL    string    `json:"html_url"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type ActionsJob struct {
+	ID          int64         `json:"id"`
+	Name        string        `json:"name"`
+	Status      string        `json:"status"`
+	Conclusion  string        `json:"conclusion"`
+	StartedAt   time.Time     `json:"started_at"`
+	CompletedAt time.Time     `json:"completed_at"`
+	HTMLURL     string        `json:"html_url"`
+	Steps       []ActionsStep `json:"steps"`
+}
+
+type ActionsStep struct {
+	Number      int       `json:"number"`
+	Name        string    `json:"name"`
+	Status      string    `json:"status"`
+	Conclusion  string    `json:"conclusion"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+type RunsParams struct {
+	// Branch is the current one by default, ignored when the commit is set.
+	Branch string
+	Commit string
+	Limit  int
+}
+
+// RunFailedError is returned once a watched run completes without succeeding.
+type RunFailedError struct {
+	Run WorkflowRun
+}
+
+func (e *RunFailedError) Error() string {
+	return fmt.Sprintf("%s #%d concluded with %s: %s", e.Run.Name, e.Run.RunNumber, e.Run.Conclusion, e.Run.HTMLURL)
+}
+
+func (e *RunFailedError) ExitCode() int {
+	return 1
+}
+
+func isRunCompleted(status string) bool {
+	return status == "completed"
+}
+
+func isConclusionSuccess(conclusion string) bool {
+	return conclusion == "success" || conclusion == "skipped" || conclusion == "neutral"
+}
+
+func (gh *GitHub) getRepoEndpoint(m *core.Manifest) string {
+	return fmt.Sprintf("/repos/%s/%s", gh.cfg.GitHub.Organization, m.Repository)
+}
+
+// getAuthHeader is used with the plain HTTP client, the go-github version in use predates the Actions API.
+func (gh *GitHub) getAuthHeader() http.Header {
+	return http.Header{"Authorization": []string{"token " + gh.cfg.GitHub.Token}}
+}
+
+func (gh *GitHub) doAPI(method, endpoint string, query url.Values, body, result interface{}) error {
+	uri := githubAPI + endpoint
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+	header := gh.getAuthHeader()
+	header.Set("Accept", "application/vnd.github+json")
+	return utils.DoJSON(method, uri, header, body, result)
+}
+
+func (gh *GitHub) getRuns(m *core.Manifest, params RunsParams) ([]WorkflowRun, error) {
+	query := url.Values{}
+	if params.Commit != "" {
+		query.Set("head_sha", params.Commit)
+	} else if params.Branch != "" {
+		query.Set("branch", params.Branch)
+	}
+	if params.Limit > 0 {
+		query.Set("per_page", fmt.Sprint(params.Limit))
+	}
+	var result struct {
+		WorkflowRuns []WorkflowRun `json:"workflow_runs"`
+	}
+	err := gh.doAPI(http.MethodGet, gh.getRepoEndpoint(m)+"/actions/runs", query, nil, &result)
+	return result.WorkflowRuns, err
+}
+
+// getRunsOrHEAD returns the run, or the runs of the current commit when the ID is 0.
+func (gh *GitHub) getRunsOrHEAD(m *core.Manifest, id int64) ([]WorkflowRun, error) {
+	if id != 0 {
+		var run WorkflowRun
+		endpoint := fmt.Sprintf("%s/actions/runs/%d", gh.getRepoEndpoint(m), id)
+		if err := gh.doAPI(http.MethodGet, endpoint, nil, nil, &run); err != nil {
+			return nil, err
+		}
+		return []WorkflowRun{run}, nil
+	}
+	head, err := core.MustInitGit(".").CurrentHEAD()
+	if err != nil {
+		return nil, err
+	}
+	runs, err := gh.getRuns(m, RunsParams{Commit: head})
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("no workflow run for %s, has it been pushed?", head)
+	}
+	return latestRuns(runs), nil
+}
+
+// latestRuns keeps the most recent run of each workflow, the runs are sorted by creation.
+func latestRuns(runs []WorkflowRun) []WorkflowRun {
+	seen := map[string]bool{}
+	var latest []WorkflowRun
+	for _, r := range runs {
+		if seen[r.Name] {
+			continue
+		}
+		seen[r.Name] = true
+		latest = append(latest, r)
+	}
+	return latest
+}
+
+func (gh *GitHub) getRunJobs(m *core.Manifest, run WorkflowRun) ([]ActionsJob, error) {
+	var result struct {
+		Jobs []ActionsJob `json:"jobs"`
+	}
+	endpoint := fmt.Sprintf("%s/actions/runs/%d/jobs", gh.getRepoEndpoint(m), run.ID)
+	err := gh.doAPI(http.MethodGet, endpoint, url.Values{"per_page": []string{"100"}}, nil, &result)
+	return result.Jobs, err
+}
+
+func formatRunState(status, conclusion string) string {
+	if isRunCompleted(status) {
+		return conclusion
+	}
+	return status
+}
+
+// ListRuns prints the workflow runs of the branch or the commit.
+func (gh *GitHub) ListRuns(m *core.Manifest, params RunsParams) error {
+	if params.Commit == "" && params.Branch == "" {
+		params.Branch = core.InitGit().GetCurrentBranch()
+	}
+	runs, err := gh.getRuns(m, params)
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		log.Print("No workflow runs found.")
+		return nil
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tWorkflow\tEvent\tState\tCommit\tCreated\tDuration\tURL")
+	for _, r := range runs {
+		fmt.Fprintf(table, "%d\t%s #%d\t%s\t%s\t%.7s\t%s\t%s\t%s\n",
+			r.ID, r.Name, r.RunNumber, r.Event, formatRunState(r.Status, r.Conclusion), r.HeadSHA,
+			r.CreatedAt.Local().Format("2006-01-02 15:04"), ci.FormatDuration(r.CreatedAt, r.UpdatedAt), r.HTMLURL)
+	}
+	return table.Flush()
+}
+
+// printRun shows the jobs of the run, with the steps of the jobs not completed or failed.
+func printRun(w io.Writer, run WorkflowRun, jobs []ActionsJob) {
+	fmt.Fprintf(w, "%s #%d: %s %s\n", run.Name, run.RunNumber, formatRunState(run.Status, run.Conclusion), run.HTMLURL)
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, j := range jobs {
+		fmt.Fprintf(table, "  %s\t%s\t%s\n", j.Name, formatRunState(j.Status, j.Conclusion), ci.FormatDuration(j.StartedAt, j.CompletedAt))
+		if isRunCompleted(j.Status) && isConclusionSuccess(j.Conclusion) {
+			continue
+		}
+		for _, s := range j.Steps {
+			if s.Status == "queued" || (isRunCompleted(s.Status) && s.Conclusion == "skipped") {
+				continue
+			}
+			fmt.Fprintf(table, "    %d. %s\t%s\t%s\n", s.Number, s.Name, formatRunState(s.Status, s.Conclusion), ci.FormatDuration(s.StartedAt, s.CompletedAt))
+		}
+	}
+	table.Flush()
+}
+
+// WatchRuns follows the run, or the runs of the current commit, until they complete.
+func (gh *GitHub) WatchRuns(m *core.Manifest, id int64) error {
+	runs, err := gh.getRunsOrHEAD(m, id)
+	if err != nil {
+		return err
+	}
+	var previous string
+	for {
+		var out bytes.Buffer
+		var state string
+		completed := true
+		for i, r := range runs {
+			endpoint := fmt.Sprintf("%s/actions/runs/%d", gh.getRepoEndpoint(m), r.ID)
+			if err = gh.doAPI(http.MethodGet, endpoint, nil, nil, &runs[i]); err != nil {
+				return err
+			}
+			jobs, err := gh.getRunJobs(m, runs[i])
+			if err != nil {
+				return err
+			}
+			printRun(&out, runs[i], jobs)
+			state += getRunState(runs[i], jobs) + "\n"
+			completed = completed && isRunCompleted(runs[i].Status)
+		}
+		if state != previous {
+			fmt.Println()
+			fmt.Print(out.String())
+			previous = state
+		}
+		if completed {
+			break
+		}
+		time.Sleep(10 * time.Second)
+	}
+	for _, r := range runs {
+		if !isConclusionSuccess(r.Conclusion) {
+			return &RunFailedError{r}
+		}
+	}
+	return nil
+}
+
+// getRunState only changes with the status of the jobs and the steps, unlike the durations.
+func getRunState(run WorkflowRun, jobs []ActionsJob) string {
+	state := run.Status + run.Conclusion
+	for _, j := range jobs {
+		state += "|" + j.Status + j.Conclusion
+		for _, s := range j.Steps {
+			state += "," + s.Status + s.Conclusion
+		}
+	}
+	return state
+}
+
+// RerunRuns reruns the failed jobs of the run, or of the failed runs of the current commit.
+func (gh *GitHub) RerunRuns(m *core.Manifest, id int64, all, async bool) error {
+	runs, err := gh.getRunsOrHEAD(m, id)
+	if err != nil {
+		return err
+	}
+	rerun := 0
+	for _, r := range runs {
+		if !isRunCompleted(r.Status) {
+			return fmt.Errorf("%s #%d is still %s", r.Name, r.RunNumber, r.Status)
+		}
+		if id == 0 && isConclusionSuccess(r.Conclusion) {
+			continue
+		}
+		action := "rerun-failed-jobs"
+		if all {
+			action = "rerun"
+		}
+		endpoint := fmt.Sprintf("%s/actions/runs/%d/%s", gh.getRepoEndpoint(m), r.ID, action)
+		if err = gh.doAPI(http.MethodPost, endpoint, nil, nil, nil); err != nil {
+			return err
+		}
+		log.Printf("Rerunning %s #%d (%s).", r.Name, r.RunNumber, r.Conclusion)
+		rerun++
+	}
+	if rerun == 0 {
+		return errors.New("no failed workflow run for the current commit")
+	}
+	if async {
+		return nil
+	}
+	// the rerun is not visible right away.
+	time.Sleep(5 * time.Second)
+	return gh.WatchRuns(m, id)
+}
+
+// DownloadRunArtifacts downloads the zipped artifacts of the run, or of the runs of the current commit.
+func (gh *GitHub) DownloadRunArtifacts(m *core.Manifest, id int64, params ci.ArtifactParams) error {
+	runs, err := gh.getRunsOrHEAD(m, id)
+	if err != nil {
+		return err
+	}
+	return gh.downloadArtifacts(m, runs, params)
+}
+
+func (gh *GitHub) downloadArtifacts(m *core.Manifest, runs []WorkflowRun, params ci.ArtifactParams) error {
+	var artifacts []ci.Artifact
+	for _, r := range runs {
+		var result struct {
+			Artifacts []struct {
+				Name               string `json:"name"`
+				Expired            bool   `json:"expired"`
+				Digest             string `json:"digest"`
+				ArchiveDownloadURL string `json:"archive_download_url"`
+			} `json:"artifacts"`
+		}
+		endpoint := fmt.Sprintf("%s/actions/runs/%d/artifacts", gh.getRepoEndpoint(m), r.ID)
+		if err := gh.doAPI(http.MethodGet, endpoint, url.Values{"per_page": []string{"100"}}, nil, &result); err != nil {
+			return err
+		}
+		for _, a := range result.Artifacts {
+			if a.Expired {
+				log.Printf("%s of %s #%d expired, skipping.", a.Name, r.Name, r.RunNumber)
+				continue
+			}
+			// the size is the one of the content, not of the archive.
+			artifacts = append(artifacts, ci.Artifact{
+				Path:     a.Name + ".zip",
+				URL:      a.ArchiveDownloadURL,
+				Size:     -1,
+				Checksum: a.Digest,
+				Header:   gh.getAuthHeader(),
+			})
+		}
+	}
+	if len(artifacts) == 0 {
+		return errors.New("no artifacts found")
+	}
+	return ci.DownloadArtifacts(artifacts, params)
+}
+
+// getJobLog returns the log of the job, the API redirects to a short lived URL.
+func (gh *GitHub) getJobLog(m *core.Manifest, job ActionsJob) (string, error) {
+	endpoint := fmt.Sprintf("%s/actions/jobs/%d/logs", gh.getRepoEndpoint(m), job.ID)
+	req, err := http.NewRequest(http.MethodGet, githubAPI+endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header = gh.getAuthHeader()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch the log of %s: %s", job.Name, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	return string(data), err
+}
+
+// filterStepLog keeps the lines of the log timestamped during the step.
+// The step times are truncated to the second, the lines without a timestamp follow the previous one.
+func filterStepLog(jobLog string, step ActionsStep) []string {
+	var lines []string
+	in := false
+	scanner := bufio.NewScanner(strings.NewReader(jobLog))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		parts := strings.SplitN(line, " ", 2)
+		if t, err := time.Parse(time.RFC3339Nano, parts[0]); err == nil {
+			in = !t.Before(step.StartedAt) && t.Before(step.CompletedAt.Add(time.Second))
+			if len(parts) == 2 {
+				line = parts[1]
+			} else {
+				line = ""
+			}
+		}
+		if in {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// ShowRunLogs prints the end of the log of the failed steps, or of the named job.
+func (gh *GitHub) ShowRunLogs(m *core.Manifest, id int64, jobName string) error {
+	runs, err := gh.getRunsOrHEAD(m, id)
+	if err != nil {
+		return err
+	}
+	return gh.showLogs(m, runs, jobName)
+}
+
+func (gh *GitHub) showLogs(m *core.Manifest, runs []WorkflowRun, jobName string) error {
+	shown := 0
+	for _, r := range runs {
+		jobs, err := gh.getRunJobs(m, r)
+		if err != nil {
+			return err
+		}
+		for _, j := range jobs {
+			failed := isRunCompleted(j.Status) && !isConclusionSuccess(j.Conclusion)
+			if (jobName != "" && j.Name != jobName) || (jobName == "" && !failed) {
+				continue
+			}
+			jobLog, err := gh.getJobLog(m, j)
+			if err != nil {
+				return err
+			}
+			shown++
+			var failedSteps []ActionsStep
+			for _, s := range j.Steps {
+				if isRunCompleted(s.Status) && !isConclusionSuccess(s.Conclusion) {
+					failedSteps = append(failedSteps, s)
+				}
+			}
+			if len(failedSteps) == 0 {
+				fmt.Printf("\n=== %s / %s\n%s\n", r.Name, j.Name, ci.ExcerptTail(jobLog, ci.FailedStepLines))
+				continue
+			}
+			for _, s := range failedSteps {
+				fmt.Printf("\n=== %s / %s: %s\n%s\n", r.Name, j.Name, s.Name, ci.ExcerptTail(strings.Join(filterStepLog(jobLog, s), "\n"), ci.FailedStepLines))
+			}
+		}
+	}
+	if shown == 0 {
+		if jobName != "" {
+			return fmt.Errorf("no job named %s", jobName)
+		}
+		log.Print("No failed jobs.")
+	}
+	return nil
+}
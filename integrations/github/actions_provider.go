@@ -0,0 +1,143 @@
+package github
+
+import (
+	"errors"
+	"fmt"
+	"github.com/benchlabs/bub/core"
+	"github.com/benchlabs/bub/integrations/ci"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// actionsProvider binds the GitHub client to the manifest of the repository, for the ci commands.
+type actionsProvider struct {
+	*GitHub
+	manifest *core.Manifest
+}
+
+func NewActionsProvider(cfg *core.Configuration, m *core.Manifest) ci.Provider {
+	return &actionsProvider{GitHub: MustInitGitHub(cfg), manifest: m}
+}
+
+func (a *actionsProvider) Name() string {
+	return "github"
+}
+
+func (a *actionsProvider) findRuns(commit string) ([]WorkflowRun, error) {
+	runs, err := a.getRuns(a.manifest, RunsParams{Commit: commit})
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, ci.ErrNoBuildForCommit
+	}
+	return latestRuns(runs), nil
+}
+
+// getRunsState is running until every run completes, on hold when one waits for an approval.
+func getRunsState(runs []WorkflowRun) string {
+	state := ci.StateSuccess
+	for _, r := range runs {
+		switch {
+		case r.Status == "waiting":
+			return ci.StateOnHold
+		case !isRunCompleted(r.Status):
+			state = ci.StateRunning
+		case !isConclusionSuccess(r.Conclusion) && state != ci.StateRunning:
+			state = ci.StateFailed
+		}
+	}
+	return state
+}
+
+// Trigger dispatches the workflow of the manifest on the branch, the parameters are the inputs of the workflow.
+func (a *actionsProvider) Trigger(params map[string]string) error {
+	if a.manifest.GitHub.Workflow == "" {
+		return errors.New("set 'github.workflow' in the manifest to the workflow to dispatch, e.g. ci.yml")
+	}
+	body := map[string]interface{}{"ref": a.manifest.Branch}
+	if len(params) > 0 {
+		body["inputs"] = params
+	}
+	endpoint := fmt.Sprintf("%s/actions/workflows/%s/dispatches", a.getRepoEndpoint(a.manifest), url.PathEscape(a.manifest.GitHub.Workflow))
+	if err := a.doAPI(http.MethodPost, endpoint, nil, body, nil); err != nil {
+		return err
+	}
+	log.Printf("Dispatched %s on %s.", a.manifest.GitHub.Workflow, a.manifest.Branch)
+	return nil
+}
+
+func (a *actionsProvider) Status(commit string) (*ci.BuildStatus, error) {
+	runs, err := a.findRuns(commit)
+	if err != nil {
+		return nil, err
+	}
+	uri := fmt.Sprintf("https://github.com/%s/%s/commit/%s/checks", a.cfg.GitHub.Organization, a.manifest.Repository, commit)
+	if len(runs) == 1 {
+		uri = runs[0].HTMLURL
+	}
+	return &ci.BuildStatus{Provider: a.Name(), Commit: commit, State: getRunsState(runs), URL: uri}, nil
+}
+
+func (a *actionsProvider) Wait(commit string, timeout time.Duration) (*ci.BuildStatus, error) {
+	return ci.WaitForCommit(commit, timeout, func() (*ci.BuildStatus, error) { return a.Status(commit) })
+}
+
+func (a *actionsProvider) Logs(commit string) error {
+	runs, err := a.findRuns(commit)
+	if err != nil {
+		return err
+	}
+	return a.showLogs(a.manifest, runs, "")
+}
+
+func (a *actionsProvider) Artifacts(commit string, params ci.ArtifactParams) error {
+	runs, err := a.findRuns(commit)
+	if err != nil {
+		return err
+	}
+	return a.downloadArtifacts(a.manifest, runs, params)
+}
+
+// Tests prints the failure annotations of the failed jobs, the test reporters publish them.
+func (a *actionsProvider) Tests(commit string) error {
+	runs, err := a.findRuns(commit)
+	if err != nil {
+		return err
+	}
+	failures := 0
+	for _, r := range runs {
+		jobs, err := a.getRunJobs(a.manifest, r)
+		if err != nil {
+			return err
+		}
+		for _, j := range jobs {
+			if !isRunCompleted(j.Status) || isConclusionSuccess(j.Conclusion) {
+				continue
+			}
+			var annotations []struct {
+				Path      string `json:"path"`
+				StartLine int    `json:"start_line"`
+				Level     string `json:"annotation_level"`
+				Title     string `json:"title"`
+				Message   string `json:"message"`
+			}
+			// the check run of a job shares its ID.
+			endpoint := fmt.Sprintf("%s/check-runs/%d/annotations", a.getRepoEndpoint(a.manifest), j.ID)
+			if err = a.doAPI(http.MethodGet, endpoint, url.Values{"per_page": []string{"100"}}, nil, &annotations); err != nil {
+				return err
+			}
+			for _, an := range annotations {
+				if an.Level != "failure" {
+					continue
+				}
+				failures++
+				fmt.Printf("\n[%s / %s] %s:%d %s\n%s\n", r.Name, j.Name, an.Path, an.StartLine, an.Title, ci.ExcerptTail(an.Message, ci.FailedStepLines))
+			}
+		}
+	}
+	log.Printf("%d failed tests.", failures)
+	return nil
+}
@@ -0,0 +1,39 @@
+package github
+
+import (
+	"github.com/benchlabs/bub/integrations/ci"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFilterStepLog(t *testing.T) {
+	jobLog := `2024-05-02T10:00:00.1000000Z ##[group]Run actions/checkout@v4
+2024-05-02T10:00:01.5000000Z checked out
+2024-05-02T10:00:02.2000000Z ##[group]Run make test
+2024-05-02T10:00:09.9000000Z --- FAIL: TestSomething
+continued without timestamp
+2024-05-02T10:00:11.0000000Z ##[group]Post job cleanup.`
+	step := ActionsStep{
+		StartedAt:   time.Date(2024, 5, 2, 10, 0, 2, 0, time.UTC),
+		CompletedAt: time.Date(2024, 5, 2, 10, 0, 10, 0, time.UTC),
+	}
+	assert.Equal(t, []string{
+		"##[group]Run make test",
+		"--- FAIL: TestSomething",
+		"continued without timestamp",
+	}, filterStepLog(jobLog, step))
+}
+
+func TestLatestRuns(t *testing.T) {
+	runs := []WorkflowRun{{ID: 3, Name: "ci"}, {ID: 2, Name: "lint"}, {ID: 1, Name: "ci"}}
+	assert.Equal(t, []WorkflowRun{{ID: 3, Name: "ci"}, {ID: 2, Name: "lint"}}, latestRuns(runs))
+}
+
+func TestGetRunsState(t *testing.T) {
+	completed := func(conclusion string) WorkflowRun { return WorkflowRun{Status: "completed", Conclusion: conclusion} }
+	assert.Equal(t, ci.StateSuccess, getRunsState([]WorkflowRun{completed("success"), completed("skipped")}))
+	assert.Equal(t, ci.StateFailed, getRunsState([]WorkflowRun{completed("success"), completed("failure")}))
+	assert.Equal(t, ci.StateRunning, getRunsState([]WorkflowRun{completed("failure"), {Status: "in_progress"}}))
+	assert.Equal(t, ci.StateOnHold, getRunsState([]WorkflowRun{{Status: "in_progress"}, {Status: "waiting"}}))
+}
@@ -46,6 +46,26 @@ func mustLoadGitHubToken(cfg *core.Configuration) {
 	}
 }
 
+// CheckGitHub verifies the stored token without prompting and returns its OAuth scopes.
+func CheckGitHub(cfg *core.Configuration) ([]string, error) {
+	if err := core.LookupCredentialItem("GitHub Token", &cfg.GitHub.Token); err != nil {
+		return nil, err
+	}
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: cfg.GitHub.Token})
+	client := github.NewClient(oauth2.NewClient(context.Background(), ts))
+	_, resp, err := client.Users.Get(context.Background(), "")
+	if err != nil {
+		return nil, err
+	}
+	var scopes []string
+	for _, s := range strings.Split(resp.Header.Get("X-OAuth-Scopes"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes, nil
+}
+
 func MustSetupGitHub(cfg *core.Configuration) {
 	if utils.AskForConfirmation(
 		"Create a new GitHub Token. " +
@@ -0,0 +1,371 @@
+package github
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/benchlabs/bub/core"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	RoleAuthor   = "author"
+	RoleReviewer = "review-requested"
+)
+
+// comparisons bounds the concurrent calls computing how far behind the PRs are.
+const comparisons = 8
+
+const prStatusQuery = `query($search: String!, $cursor: String) {
+  search(query: $search, type: ISSUE, first: 50, after: $cursor) {
+    pageInfo { hasNextPage endCursor }
+    nodes {
+      ... on PullRequest {
+        number title url isDraft updatedAt headRefName baseRefName headRefOid mergeable reviewDecision
+        author { login }
+        repository { name }
+        reviewRequests(first: 20) {
+          nodes { requestedReviewer { ... on User { login } ... on Team { slug } } }
+        }
+        commits(last: 1) {
+          nodes {
+            commit {
+              statusCheckRollup {
+                state
+                contexts(first: 100) {
+                  nodes {
+                    ... on CheckRun { name status conclusion }
+                    ... on StatusContext { context state }
+                  }
+                }
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+type PRStatus struct {
+	Repository string `json:"repository"`
+	Number     int    `json:"number"`
+	Title      string `json:"title"`
+	URL        string `json:"url"`
+	Author     string `json:"author"`
+	// Role is author or review-requested.
+	Role  string `json:"role"`
+	Draft bool   `json:"draft"`
+	// Checks is the combined state of the statuses and the check runs, SUCCESS, PENDING, FAILURE or ERROR.
+	Checks         string   `json:"checks"`
+	FailedChecks   []string `json:"failedChecks,omitempty"`
+	ReviewDecision string   `json:"reviewDecision"`
+	Reviewers      []string `json:"requestedReviewers,omitempty"`
+	// Mergeable is MERGEABLE, CONFLICTING or UNKNOWN while GitHub computes it.
+	Mergeable string `json:"mergeable"`
+	// Behind is the number of commits of the base branch missing from the PR, -1 when unknown.
+	Behind    int       `json:"behind"`
+	Issue     string    `json:"issue,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	base, head string
+}
+
+type PRStatusParams struct {
+	// Role is author or review-requested, both when empty.
+	Role       string
+	Repository string
+	// Checks keeps the PRs with the combined state, e.g. failure.
+	Checks string
+	// Ready keeps the approved, green and mergeable PRs.
+	Ready bool
+	JSON  bool
+}
+
+type prNode struct {
+	Number         int       `json:"number"`
+	Title          string    `json:"title"`
+	URL            string    `json:"url"`
+	IsDraft        bool      `json:"isDraft"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+	HeadRefName    string    `json:"headRefName"`
+	BaseRefName    string    `json:"baseRefName"`
+	HeadRefOid     string    `json:"headRefOid"`
+	Mergeable      string    `json:"mergeable"`
+	ReviewDecision string    `json:"reviewDecision"`
+	Author         struct {
+		Login string `json:"login"`
+	} `json:"author"`
+	Repository struct {
+		Name string `json:"name"`
+	} `json:"repository"`
+	ReviewRequests struct {
+		Nodes []struct {
+			RequestedReviewer struct {
+				Login string `json:"login"`
+				Slug  string `json:"slug"`
+			} `json:"requestedReviewer"`
+		} `json:"nodes"`
+	} `json:"reviewRequests"`
+	Commits struct {
+		Nodes []struct {
+			Commit struct {
+				StatusCheckRollup *struct {
+					State    string `json:"state"`
+					Contexts struct {
+						Nodes []struct {
+							Name       string `json:"name"`
+							Status     string `json:"status"`
+							Conclusion string `json:"conclusion"`
+							Context    string `json:"context"`
+							State      string `json:"state"`
+						} `json:"nodes"`
+					} `json:"contexts"`
+				} `json:"statusCheckRollup"`
+			} `json:"commit"`
+		} `json:"nodes"`
+	} `json:"commits"`
+}
+
+func (gh *GitHub) searchPRStatuses(role string) ([]prNode, error) {
+	search := fmt.Sprintf("is:open is:pr archived:false org:%s %s:%s", gh.cfg.GitHub.Organization, role, gh.cfg.GitHub.Username)
+	var nodes []prNode
+	variables := map[string]interface{}{"search": search}
+	for {
+		body := map[string]interface{}{"query": prStatusQuery, "variables": variables}
+		var result struct {
+			Data struct {
+				Search struct {
+					PageInfo struct {
+						HasNextPage bool   `json:"hasNextPage"`
+						EndCursor   string `json:"endCursor"`
+					} `json:"pageInfo"`
+					Nodes []prNode `json:"nodes"`
+				} `json:"search"`
+			} `json:"data"`
+			Errors []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		}
+		if err := gh.doAPI(http.MethodPost, "/graphql", nil, body, &result); err != nil {
+			return nil, err
+		}
+		if len(result.Errors) > 0 {
+			var messages []string
+			for _, e := range result.Errors {
+				messages = append(messages, e.Message)
+			}
+			return nil, errors.New(strings.Join(messages, ", "))
+		}
+		nodes = append(nodes, result.Data.Search.Nodes...)
+		if !result.Data.Search.PageInfo.HasNextPage {
+			return nodes, nil
+		}
+		variables["cursor"] = result.Data.Search.PageInfo.EndCursor
+	}
+}
+
+func toPRStatus(n prNode, role string) PRStatus {
+	s := PRStatus{
+		Repository:     n.Repository.Name,
+		Number:         n.Number,
+		Title:          n.Title,
+		URL:            n.URL,
+		Author:         n.Author.Login,
+		Role:           role,
+		Draft:          n.IsDraft,
+		ReviewDecision: n.ReviewDecision,
+		Mergeable:      n.Mergeable,
+		Behind:         -1,
+		UpdatedAt:      n.UpdatedAt,
+		base:           n.BaseRefName,
+		head:           n.HeadRefOid,
+	}
+	issueRegex := core.InitGit().GetIssueRegex()
+	if s.Issue = issueRegex.FindString(n.Title); s.Issue == "" {
+		s.Issue = issueRegex.FindString(strings.ToUpper(n.HeadRefName))
+	}
+	for _, r := range n.ReviewRequests.Nodes {
+		if r.RequestedReviewer.Login != "" {
+			s.Reviewers = append(s.Reviewers, r.RequestedReviewer.Login)
+		} else if r.RequestedReviewer.Slug != "" {
+			s.Reviewers = append(s.Reviewers, "@"+r.RequestedReviewer.Slug)
+		}
+	}
+	if len(n.Commits.Nodes) == 0 || n.Commits.Nodes[0].Commit.StatusCheckRollup == nil {
+		return s
+	}
+	rollup := n.Commits.Nodes[0].Commit.StatusCheckRollup
+	s.Checks = rollup.State
+	for _, c := range rollup.Contexts.Nodes {
+		switch {
+		case c.Name != "" && c.Status == "COMPLETED" && c.Conclusion != "SUCCESS" && c.Conclusion != "SKIPPED" && c.Conclusion != "NEUTRAL":
+			s.FailedChecks = append(s.FailedChecks, c.Name)
+		case c.Context != "" && (c.State == "FAILURE" || c.State == "ERROR"):
+			s.FailedChecks = append(s.FailedChecks, c.Context)
+		}
+	}
+	return s
+}
+
+func (gh *GitHub) getBehindCount(s PRStatus) (int, error) {
+	var result struct {
+		BehindBy int `json:"behind_by"`
+	}
+	endpoint := fmt.Sprintf("/repos/%s/%s/compare/%s...%s", gh.cfg.GitHub.Organization, s.Repository, s.base, s.head)
+	err := gh.doAPI(http.MethodGet, endpoint, nil, nil, &result)
+	return result.BehindBy, err
+}
+
+func (s PRStatus) isReady() bool {
+	return !s.Draft && s.ReviewDecision == "APPROVED" && s.Checks == "SUCCESS" && s.Mergeable == "MERGEABLE"
+}
+
+func filterPRStatuses(statuses []PRStatus, params PRStatusParams) []PRStatus {
+	var filtered []PRStatus
+	for _, s := range statuses {
+		if params.Repository != "" && s.Repository != params.Repository {
+			continue
+		}
+		if params.Checks != "" && !strings.EqualFold(s.Checks, params.Checks) {
+			continue
+		}
+		if params.Ready && !s.isReady() {
+			continue
+		}
+		filtered = append(filtered, s)
+	}
+	return filtered
+}
+
+// GetPRStatuses returns the open PRs of the user and the ones waiting for their review, across the organization.
+func (gh *GitHub) GetPRStatuses(params PRStatusParams) ([]PRStatus, error) {
+	roles := []string{RoleAuthor, RoleReviewer}
+	if params.Role != "" {
+		if params.Role != RoleAuthor && params.Role != RoleReviewer {
+			return nil, fmt.Errorf("unknown role '%s', use %s or %s", params.Role, RoleAuthor, RoleReviewer)
+		}
+		roles = []string{params.Role}
+	}
+	var statuses []PRStatus
+	for _, role := range roles {
+		nodes, err := gh.searchPRStatuses(role)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range nodes {
+			statuses = append(statuses, toPRStatus(n, role))
+		}
+	}
+	statuses = filterPRStatuses(statuses, params)
+
+	sem := make(chan bool, comparisons)
+	wg := sync.WaitGroup{}
+	for i := range statuses {
+		wg.Add(1)
+		sem <- true
+		go func(s *PRStatus) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			behind, err := gh.getBehindCount(*s)
+			if err != nil {
+				log.Printf("Failed to compare %s#%d with %s: %v", s.Repository, s.Number, s.base, err)
+				return
+			}
+			s.Behind = behind
+		}(&statuses[i])
+	}
+	wg.Wait()
+	sort.SliceStable(statuses, func(i, j int) bool {
+		if statuses[i].Role != statuses[j].Role {
+			return statuses[i].Role == RoleAuthor
+		}
+		return statuses[i].UpdatedAt.After(statuses[j].UpdatedAt)
+	})
+	return statuses, nil
+}
+
+func formatChecks(s PRStatus) string {
+	if s.Checks == "" {
+		return "-"
+	}
+	if len(s.FailedChecks) > 0 {
+		return fmt.Sprintf("%s (%s)", s.Checks, strings.Join(s.FailedChecks, ", "))
+	}
+	return s.Checks
+}
+
+func formatBehind(behind int) string {
+	if behind < 0 {
+		return "?"
+	}
+	return fmt.Sprint(behind)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// ShowPRStatuses prints the dashboard of the PRs, by role.
+func (gh *GitHub) ShowPRStatuses(params PRStatusParams) error {
+	statuses, err := gh.GetPRStatuses(params)
+	if err != nil {
+		return err
+	}
+	if params.JSON {
+		if statuses == nil {
+			statuses = []PRStatus{}
+		}
+		data, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	if len(statuses) == 0 {
+		log.Print("No open PRs.")
+		return nil
+	}
+	var table *tabwriter.Writer
+	role := ""
+	for _, s := range statuses {
+		if s.Role != role {
+			if table != nil {
+				table.Flush()
+				fmt.Println()
+			}
+			role = s.Role
+			if role == RoleAuthor {
+				fmt.Println("My PRs")
+			} else {
+				fmt.Println("Review requests")
+			}
+			table = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(table, "PR\tTitle\tIssue\tChecks\tReview\tReviewers\tMergeable\tBehind\tURL")
+		}
+		title := s.Title
+		if s.Draft {
+			title = "[draft] " + title
+		}
+		if s.Role == RoleReviewer {
+			title += " (@" + s.Author + ")"
+		}
+		fmt.Fprintf(table, "%s#%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			s.Repository, s.Number, title, orDash(s.Issue), formatChecks(s), orDash(s.ReviewDecision),
+			orDash(strings.Join(s.Reviewers, ", ")), orDash(s.Mergeable), formatBehind(s.Behind), s.URL)
+	}
+	return table.Flush()
+}
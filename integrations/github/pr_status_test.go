@@ -0,0 +1,31 @@
+package github
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestToPRStatus(t *testing.T) {
+	var n prNode
+	err := json.Unmarshal([]byte(`{
+		"number": 12, "title": "Retry the uploads", "headRefName": "plat-42-retry", "baseRefName": "master",
+		"mergeable": "MERGEABLE", "reviewDecision": "APPROVED",
+		"repository": {"name": "bub"},
+		"reviewRequests": {"nodes": [{"requestedReviewer": {"login": "alice"}}, {"requestedReviewer": {"slug": "platform"}}]},
+		"commits": {"nodes": [{"commit": {"statusCheckRollup": {"state": "FAILURE", "contexts": {"nodes": [
+			{"name": "build", "status": "COMPLETED", "conclusion": "SUCCESS"},
+			{"name": "lint", "status": "COMPLETED", "conclusion": "FAILURE"},
+			{"context": "ci/jenkins", "state": "ERROR"}
+		]}}}}]}
+	}`), &n)
+	assert.NoError(t, err)
+
+	s := toPRStatus(n, RoleAuthor)
+	assert.Equal(t, "PLAT-42", s.Issue)
+	assert.Equal(t, []string{"alice", "@platform"}, s.Reviewers)
+	assert.Equal(t, "FAILURE", s.Checks)
+	assert.Equal(t, []string{"lint", "ci/jenkins"}, s.FailedChecks)
+	assert.Equal(t, -1, s.Behind)
+	assert.False(t, s.isReady())
+}
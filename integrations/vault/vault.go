@@ -10,8 +10,6 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	"os/user"
-	"path"
 	"strings"
 )
 
@@ -43,6 +41,30 @@ func MustInitVault(cfg *core.Configuration, s *ssh.Connection) *Vault {
 	return v
 }
 
+// CheckToken validates the cached token for the tunnel without re-authenticating.
+func CheckToken(cfg *core.Configuration, s *ssh.Connection) error {
+	tunnel := s.Tunnels["vault"]
+	v := &Vault{cfg: cfg, tokenName: "token." + tunnel.RemoteHost}
+	vaultCfg := api.DefaultConfig()
+	vaultCfg.Address = fmt.Sprintf("%v:%v", cfg.Vault.Server, tunnel.LocalPort)
+	client, err := api.NewClient(vaultCfg)
+	if err != nil {
+		return err
+	}
+	v.client = client
+	filePath, err := v.getTokenPath()
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return errors.New("no token cached, run a command using Vault to log in")
+	}
+	client.SetToken(strings.Trim(string(content), "\n"))
+	_, err = client.Auth().Token().LookupSelf()
+	return err
+}
+
 func MustSetupVault(cfg *core.Configuration) {
 	mustLoadVaultCredentials(cfg)
 }
@@ -58,12 +80,7 @@ func mustLoadVaultCredentials(cfg *core.Configuration) {
 }
 
 func (v *Vault) getTokenPath() (string, error) {
-	usr, err := user.Current()
-	if err != nil {
-		return "", nil
-	}
-	configPath := path.Join(usr.HomeDir, ".config", "bub", v.tokenName)
-	return configPath, nil
+	return core.GetConfigPath(v.tokenName), nil
 }
 
 func (v *Vault) loadToken() error {
@@ -154,3 +171,15 @@ func (v *Vault) Write(path string, data map[string]interface{}) (*api.Secret, er
 	log.Printf("Writing to '%v' on '%v'", path, v.client.Address())
 	return v.write(path, data, 2)
 }
+
+func (v *Vault) List(path string) (*api.Secret, error) {
+	log.Printf("Listing '%v' on '%v'", path, v.client.Address())
+	secret, err := v.client.Logical().List(path)
+	if err != nil {
+		if err = v.maybeReAuth(); err != nil {
+			return nil, err
+		}
+		return v.client.Logical().List(path)
+	}
+	return secret, err
+}
@@ -13,6 +13,9 @@ func main() {
 	app.Usage = "A tool for all your needs."
 	app.Version = "0.62.2"
 	app.EnableBashCompletion = true
+	app.Flags = cmd.BuildFlags()
+	app.Before = cmd.LoadConfiguration
+	app.After = cmd.NotifyUpdate
 	app.Commands = cmd.BuildCmds()
 	app.Run(os.Args)
 }
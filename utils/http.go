@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// HTTPError is returned by DoJSON for the responses that are not a success.
+type HTTPError struct {
+	Method, URI string
+	StatusCode  int
+	Status      string
+	Body        string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s %s: %s %s", e.Method, e.URI, e.Status, e.Body)
+}
+
+// DoJSON sends the body as JSON, when set, and decodes the response into the result, when set.
+func DoJSON(method, uri string, header http.Header, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, uri, reader)
+	if err != nil {
+		return err
+	}
+	for k, values := range header {
+		for _, v := range values {
+			req.Header.Add(k, v)
+		}
+	}
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		// the query string is left out, it can hold credentials.
+		return &HTTPError{method, strings.SplitN(uri, "?", 2)[0], resp.StatusCode, resp.Status, strings.TrimSpace(string(data))}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
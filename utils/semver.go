@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var semverRegex = regexp.MustCompile(`^v?(\d+)\.(\d+)(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// Version is a semantic version, see https://semver.org.
+// The patch number is optional to accept versions like '1.2'.
+type Version struct {
+	Major, Minor, Patch int
+	PreRelease          []string
+}
+
+func ParseVersion(s string) (Version, error) {
+	m := semverRegex.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return Version{}, fmt.Errorf("invalid version: '%s'", s)
+	}
+	v := Version{}
+	v.Major, _ = strconv.Atoi(m[1])
+	v.Minor, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		v.Patch, _ = strconv.Atoi(m[3])
+	}
+	if m[4] != "" {
+		v.PreRelease = strings.Split(m[4], ".")
+	}
+	return v, nil
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.PreRelease) > 0 {
+		s += "-" + strings.Join(v.PreRelease, ".")
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 when v is lower, equal or greater than o.
+func (v Version) Compare(o Version) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d != 0 {
+			return sign(d)
+		}
+	}
+	// a pre-release has a lower precedence than the release.
+	if len(v.PreRelease) == 0 || len(o.PreRelease) == 0 {
+		return sign(len(o.PreRelease) - len(v.PreRelease))
+	}
+	for i := 0; i < len(v.PreRelease) && i < len(o.PreRelease); i++ {
+		if c := comparePreReleaseIdentifier(v.PreRelease[i], o.PreRelease[i]); c != 0 {
+			return c
+		}
+	}
+	return sign(len(v.PreRelease) - len(o.PreRelease))
+}
+
+func comparePreReleaseIdentifier(a, b string) int {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return sign(na - nb)
+	case errA == nil:
+		// numeric identifiers have a lower precedence.
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// CompareVersions compares two version strings, invalid versions are lower than any valid one.
+func CompareVersions(a, b string) int {
+	va, errA := ParseVersion(a)
+	vb, errB := ParseVersion(b)
+	switch {
+	case errA != nil && errB != nil:
+		return 0
+	case errA != nil:
+		return -1
+	case errB != nil:
+		return 1
+	}
+	return va.Compare(vb)
+}
+
+func sign(i int) int {
+	if i < 0 {
+		return -1
+	} else if i > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareVersions(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, 1, CompareVersions("0.63.0", "0.62.10"))
+	assert.Equal(t, -1, CompareVersions("0.9.0", "0.10.0"))
+	assert.Equal(t, 0, CompareVersions("v1.2", "1.2.0"))
+	assert.Equal(t, -1, CompareVersions("1.0.0-beta.2", "1.0.0"))
+	assert.Equal(t, -1, CompareVersions("1.0.0-alpha", "1.0.0-alpha.1"))
+	assert.Equal(t, -1, CompareVersions("1.0.0-beta.2", "1.0.0-beta.11"))
+	assert.Equal(t, 1, CompareVersions("1.0.0-rc.1", "1.0.0-beta.11"))
+	assert.Equal(t, 0, CompareVersions("1.0.0+build.1", "1.0.0"))
+	assert.Equal(t, -1, CompareVersions("contrib/bub2/darwin", "0.1.0"))
+}
+
+func TestParseVersion(t *testing.T) {
+	t.Parallel()
+	v, err := ParseVersion("v0.62.2-beta.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "0.62.2-beta.1", v.String())
+	_, err = ParseVersion("bub-0.62.2-darwin")
+	assert.Error(t, err)
+}
@@ -1,8 +1,10 @@
 package ssh
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/benchlabs/bub/utils"
+	"io"
 	"log"
 	"net"
 	"os"
@@ -11,6 +13,9 @@ import (
 	"time"
 )
 
+// tunnelTimeout is the time given to the ssh or SSM processes to open the tunnels.
+const tunnelTimeout = time.Minute
+
 type SSH interface {
 	Connect() error
 	Close() error
@@ -26,10 +31,17 @@ type Connection struct {
 	JumpHost string
 	Command  string
 	Tunnels  map[string]Tunnel
-	process  *os.Process
+	// SSMTarget is the instance forwarding the tunnels with SSM Session Manager, instead of the jump host.
+	SSMTarget, Region string
+	processes         []*os.Process
+	// exited receives the errors of the processes, with their stderr.
+	exited chan error
 }
 
 func (s *Connection) Connect() error {
+	if s.SSMTarget != "" {
+		return s.connectSSM()
+	}
 	args := []string{
 		"-o", "ExitOnForwardFailure yes",
 		"-N",
@@ -39,27 +51,72 @@ func (s *Connection) Connect() error {
 	}
 	args = append(args, s.JumpHost)
 	log.Printf("Connecting: ssh %v", strings.Join(args, " "))
-	tunnel := exec.Command("ssh", args...)
-	tunnel.Stderr = os.Stderr
-	err := tunnel.Start()
-	if err != nil {
+	if err := s.startProcess(exec.Command("ssh", args...)); err != nil {
+		return err
+	}
+	return s.waitForTunnels()
+}
+
+// startProcess runs the command in the background, waitForTunnels fails if it exits.
+func (s *Connection) startProcess(cmd *exec.Cmd) error {
+	var stderr bytes.Buffer
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	if err := cmd.Start(); err != nil {
 		return err
 	}
+	if s.exited == nil {
+		s.exited = make(chan error, len(s.Tunnels)+1)
+	}
+	s.processes = append(s.processes, cmd.Process)
+	go func() {
+		err := cmd.Wait()
+		s.exited <- fmt.Errorf("%s exited before the tunnels were ready: %v: %s", cmd.Args[0], err, strings.TrimSpace(stderr.String()))
+	}()
+	return nil
+}
+
+func (s *Connection) waitForTunnels() error {
 	log.Print("Waiting for tunnel(s)...")
+	deadline := time.After(tunnelTimeout)
 	for _, h := range s.Tunnels {
-		for {
-			time.Sleep(20 * time.Millisecond)
-			if IsListening(h.LocalPort) {
-				break
+		for !IsListening(h.LocalPort) {
+			select {
+			case err := <-s.exited:
+				s.Close()
+				return err
+			case <-deadline:
+				s.Close()
+				return fmt.Errorf("timed out after %v waiting for the tunnel on port %d", tunnelTimeout, h.LocalPort)
+			case <-time.After(20 * time.Millisecond):
 			}
 		}
 	}
-	s.process = tunnel.Process
 	return nil
 }
 
 func (s *Connection) Close() error {
-	return s.process.Kill()
+	var err error
+	for _, p := range s.processes {
+		if killErr := p.Kill(); killErr != nil {
+			err = killErr
+		}
+	}
+	return err
+}
+
+// CheckHost runs a no-op command on the host without prompting for passwords.
+func CheckHost(host string, connectTimeout uint) error {
+	if connectTimeout == 0 {
+		connectTimeout = 3
+	}
+	output, err := utils.RunCmdWithFullOutput("ssh",
+		"-o", "BatchMode=yes",
+		"-o", fmt.Sprintf("ConnectTimeout=%d", connectTimeout),
+		host, "true")
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
 }
 
 func GetPort() int {
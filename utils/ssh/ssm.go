@@ -0,0 +1,39 @@
+package ssh
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+)
+
+// connectSSM starts a port forwarding session per tunnel, SSM only forwards one port per session.
+// It requires the AWS CLI and the session-manager-plugin.
+func (s *Connection) connectSSM() error {
+	for _, h := range s.Tunnels {
+		parameters, err := json.Marshal(map[string][]string{
+			"host":            {h.RemoteHost},
+			"portNumber":      {strconv.Itoa(h.RemotePort)},
+			"localPortNumber": {strconv.Itoa(h.LocalPort)},
+		})
+		if err != nil {
+			return err
+		}
+		args := []string{
+			"ssm", "start-session",
+			"--target", s.SSMTarget,
+			"--document-name", "AWS-StartPortForwardingSessionToRemoteHost",
+			"--parameters", string(parameters),
+		}
+		if s.Region != "" {
+			args = append(args, "--region", s.Region)
+		}
+		log.Printf("Connecting: aws ssm start-session --target %v (%v:%v)", s.SSMTarget, h.RemoteHost, h.RemotePort)
+		if err = s.startProcess(exec.Command("aws", args...)); err != nil {
+			s.Close()
+			return fmt.Errorf("failed to start the SSM session, is the AWS CLI installed? %v", err)
+		}
+	}
+	return s.waitForTunnels()
+}